@@ -1,7 +1,13 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
 	"github.com/gleich/lumber/v3"
@@ -9,30 +15,225 @@ import (
 	"pkg.mattglei.ch/lcp-2/internal/apis/github"
 	"pkg.mattglei.ch/lcp-2/internal/apis/steam"
 	"pkg.mattglei.ch/lcp-2/internal/apis/strava"
+	"pkg.mattglei.ch/lcp-2/internal/cache"
+	"pkg.mattglei.ch/lcp-2/internal/config"
+	"pkg.mattglei.ch/lcp-2/internal/metrics"
+	"pkg.mattglei.ch/lcp-2/internal/middleware"
+	"pkg.mattglei.ch/lcp-2/internal/mock"
 	"pkg.mattglei.ch/lcp-2/internal/secrets"
+	"pkg.mattglei.ch/lcp-2/internal/tracing"
 )
 
 func main() {
 	setupLogger()
+
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "check-config":
+			checkConfig()
+			return
+		case "mock-upstreams":
+			mockUpstreams(os.Args[2:])
+			return
+		case "strava":
+			stravaCommand(os.Args[2:])
+			return
+		default:
+			lumber.FatalMsg("unknown command:", os.Args[1])
+		}
+	}
+
 	lumber.Info("booted")
 
+	config.Load()
 	secrets.Load()
+	go reloadOnSignal()
+
+	shutdownTracing, err := tracing.Setup(context.Background())
+	if err != nil {
+		lumber.Fatal(err, "failed to setup tracing")
+	}
+	go shutdownOnSignal(shutdownTracing)
 
 	mux := http.NewServeMux()
 	mux.HandleFunc("/", rootRedirect)
+	mux.HandleFunc("GET /status", cache.ServeStatus)
+	mux.HandleFunc("GET /metrics", metrics.ServeHTTP)
 
-	github.Setup(mux)
-	strava.Setup(mux)
-	steam.Setup(mux)
-	applemusic.Setup(mux)
+	if config.Get().GitHub.Enabled {
+		github.Setup(mux)
+	}
+	if config.Get().Strava.Enabled {
+		strava.Setup(mux)
+	}
+	if config.Get().Steam.Enabled {
+		steam.Setup(mux)
+	}
+	if config.Get().AppleMusic.Enabled {
+		applemusic.Setup(mux)
+	}
 
 	lumber.Info("starting server")
-	err := http.ListenAndServe(":8000", mux)
+	err = http.ListenAndServe(":8000", middleware.Trace(middleware.Log(mux)))
 	if err != nil {
 		lumber.Fatal(err, "failed to start router")
 	}
 }
 
+// validate the config and secrets without starting the server
+func checkConfig() {
+	path := config.Path()
+	conf, err := config.Read(path)
+	if err != nil {
+		lumber.Fatal(err, "loading config from", path, "failed")
+	}
+	_, errs := secrets.Check(conf)
+	if len(errs) != 0 {
+		for _, err := range errs {
+			lumber.ErrorMsg(err)
+		}
+		lumber.FatalMsg(len(errs), "problem(s) found with secrets")
+	}
+	lumber.Done("config and secrets are valid")
+}
+
+// serve fixture data for every upstream so lcp can be run without real credentials
+func mockUpstreams(args []string) {
+	flags := flag.NewFlagSet("mock-upstreams", flag.ExitOnError)
+	addr := flags.String("addr", ":8001", "address to serve the mock upstreams on")
+	lcpURL := flags.String("lcp", "http://localhost:8000", "lcp base URL to send strava webhooks to")
+	subscriptionID := flags.Int64("subscription-id", 1, "subscription ID to send strava webhooks with")
+	_ = flags.Parse(args)
+
+	err := mock.Serve(mock.Options{
+		Addr:                 *addr,
+		LCPURL:               *lcpURL,
+		StravaSubscriptionID: *subscriptionID,
+	})
+	if err != nil {
+		lumber.Fatal(err, "failed to serve mock upstreams")
+	}
+}
+
+// manage the push subscription that strava sends webhook events for
+func stravaCommand(args []string) {
+	if len(args) == 0 {
+		lumber.FatalMsg("usage: lcp strava subscribe|list|unsubscribe")
+	}
+	config.Load()
+	loadedSecrets, err := secrets.Read()
+	if err != nil {
+		lumber.Fatal(err, "failed to read secrets")
+	}
+	if loadedSecrets.StravaClientID == "" || loadedSecrets.StravaClientSecret == "" {
+		lumber.FatalMsg("STRAVA_CLIENT_ID and STRAVA_CLIENT_SECRET must be set")
+	}
+	secrets.Set(loadedSecrets)
+	ctx := context.Background()
+
+	switch args[0] {
+	case "subscribe":
+		flags := flag.NewFlagSet("strava subscribe", flag.ExitOnError)
+		callbackURL := flags.String(
+			"callback-url",
+			"",
+			"public URL of lcp's /strava/event route (e.g. https://lcp.example.com/strava/event)",
+		)
+		_ = flags.Parse(args[1:])
+		if *callbackURL == "" {
+			lumber.FatalMsg("-callback-url is required")
+		}
+		err = strava.VerifyCallback(ctx, *callbackURL)
+		if err != nil {
+			lumber.Fatal(err, "callback didn't answer the challenge; is lcp running at", *callbackURL)
+		}
+		id, err := strava.Subscribe(ctx, *callbackURL)
+		if err != nil {
+			lumber.Fatal(err, "failed to create subscription")
+		}
+		lumber.Done("subscribed with ID", id, "(set STRAVA_SUBSCRIPTION_ID to it)")
+	case "list":
+		subscriptions, err := strava.ListSubscriptions(ctx)
+		if err != nil {
+			lumber.Fatal(err, "failed to list subscriptions")
+		}
+		if len(subscriptions) == 0 {
+			lumber.Info("no subscriptions")
+		}
+		for _, subscription := range subscriptions {
+			err = strava.VerifyCallback(ctx, subscription.CallbackURL)
+			if err != nil {
+				lumber.Error(
+					err,
+					"subscription",
+					subscription.ID,
+					"for",
+					subscription.CallbackURL,
+					"didn't answer the challenge",
+				)
+				continue
+			}
+			lumber.Done("subscription", subscription.ID, "for", subscription.CallbackURL, "is working")
+		}
+	case "unsubscribe":
+		flags := flag.NewFlagSet("strava unsubscribe", flag.ExitOnError)
+		id := flags.Int64(
+			"id",
+			loadedSecrets.StravaSubscriptionID,
+			"ID of the subscription to delete (defaults to STRAVA_SUBSCRIPTION_ID)",
+		)
+		_ = flags.Parse(args[1:])
+		if *id <= 0 {
+			lumber.FatalMsg("-id or STRAVA_SUBSCRIPTION_ID is required")
+		}
+		err = strava.Unsubscribe(ctx, *id)
+		if err != nil {
+			lumber.Fatal(err, "failed to delete subscription", *id)
+		}
+		lumber.Done("deleted subscription", *id)
+	default:
+		lumber.FatalMsg("unknown strava command:", args[0])
+	}
+}
+
+// reload the config and secrets whenever a SIGHUP is received, keeping the current ones if either
+// is invalid. Enabling or disabling a provider still requires a restart.
+func reloadOnSignal() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+	for range signals {
+		// both are read and validated before either is swapped in so the secrets are never checked
+		// against a config that isn't loaded, and neither changes if the other is invalid
+		conf, err := config.Read(config.Path())
+		if err != nil {
+			lumber.Error(err, "reloading config failed")
+			continue
+		}
+		loadedSecrets, errs := secrets.Check(conf)
+		if len(errs) != 0 {
+			lumber.Error(errors.Join(errs...), "reloading secrets failed")
+			continue
+		}
+		config.Set(conf)
+		secrets.Set(loadedSecrets)
+		lumber.Done("reloaded config and secrets")
+	}
+}
+
+// flush any buffered spans before exiting on SIGINT or SIGTERM
+func shutdownOnSignal(shutdownTracing func(context.Context) error) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	<-signals
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	err := shutdownTracing(ctx)
+	if err != nil {
+		lumber.Error(err, "failed to flush spans")
+	}
+	os.Exit(0)
+}
+
 func setupLogger() {
 	nytime, err := time.LoadLocation("America/New_York")
 	if err != nil {
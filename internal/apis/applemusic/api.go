@@ -1,35 +1,32 @@
 package applemusic
 
 import (
-	"errors"
-	"fmt"
+	"context"
 	"net/http"
-	"strings"
 
 	"github.com/gleich/lumber/v3"
 	"pkg.mattglei.ch/lcp-2/internal/apis"
+	"pkg.mattglei.ch/lcp-2/internal/config"
 	"pkg.mattglei.ch/lcp-2/internal/secrets"
 )
 
-func sendAppleMusicAPIRequest[T any](path string) (T, error) {
+func sendAppleMusicAPIRequest[T any](ctx context.Context, path string) (T, error) {
 	var zeroValue T
-	req, err := http.NewRequest(
+	req, err := http.NewRequestWithContext(
+		ctx,
 		http.MethodGet,
-		fmt.Sprintf("https://api.music.apple.com/%s", strings.TrimLeft(path, "/")),
+		apis.JoinURL(config.Get().Upstreams.AppleMusic, path),
 		nil,
 	)
 	if err != nil {
 		lumber.Error(err, "failed to create request")
 		return zeroValue, err
 	}
-	req.Header.Set("Authorization", "Bearer "+secrets.SECRETS.AppleMusicAppToken)
-	req.Header.Set("Music-User-Token", secrets.SECRETS.AppleMusicUserToken)
+	req.Header.Set("Authorization", "Bearer "+secrets.Get().AppleMusicAppToken)
+	req.Header.Set("Music-User-Token", secrets.Get().AppleMusicUserToken)
 
 	resp, err := apis.SendRequest[T](req)
 	if err != nil {
-		if !errors.Is(err, apis.WarningError) {
-			lumber.Error(err, "failed to make apple music API request")
-		}
 		return zeroValue, err
 	}
 	return resp, nil
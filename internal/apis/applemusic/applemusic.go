@@ -1,51 +1,33 @@
 package applemusic
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
 	"time"
 
 	"github.com/gleich/lumber/v3"
+	"pkg.mattglei.ch/lcp-2/internal/apis"
 	"pkg.mattglei.ch/lcp-2/internal/auth"
 	"pkg.mattglei.ch/lcp-2/internal/cache"
+	"pkg.mattglei.ch/lcp-2/internal/config"
 )
 
-const API_ENDPOINT = "https://api.music.apple.com/"
-
 type cacheData struct {
 	RecentlyPlayed []song     `json:"recently_played"`
 	Playlists      []playlist `json:"playlists"`
 }
 
-func cacheUpdate() (cacheData, error) {
-	recentlyPlayed, err := fetchRecentlyPlayed()
+func cacheUpdate(ctx context.Context) (cacheData, error) {
+	recentlyPlayed, err := fetchRecentlyPlayed(ctx)
 	if err != nil {
 		return cacheData{}, err
 	}
 
-	playlistsIDs := []string{
-		"p.AWXoXPYSLrvpJlY", // alt
-		"p.LV0PX3EIl0EpDLW", // jazz
-		"p.AWXoZoxHLrvpJlY", // chill
-		"p.gek1E8efLa68Adp", // classics
-		"p.V7VYVB0hZo53MQv", // old man
-		"p.qQXLxPLtA75zg8e", // 80s
-		"p.LV0PXNoCl0EpDLW", // divorced dad
-		"p.QvDQE5RIVbAeokL", // PARTY
-		"p.LV0PXL3Cl0EpDLW", // bops
-		"p.6xZaArOsvzb5OML", // focus
-		"p.O1kz7EoFVmvz704", // funk
-		"p.qQXLxPpFA75zg8e", // RAHHHHHHHH
-		"p.qQXLxpDuA75zg8e", // ROCK
-		"p.O1kz7zbsVmvz704", // country
-		"p.QvDQEN0IVbAeokL", // fall
-		// "p.ZOAXAMZF4KMD6ob", // sad girl music
-		// "p.QvDQEebsVbAeokL", // christmas
-	}
 	playlists := []playlist{}
-	for _, id := range playlistsIDs {
-		playlistData, err := fetchPlaylist(id)
+	for _, id := range config.Get().AppleMusic.Playlists {
+		playlistData, err := fetchPlaylist(ctx, id)
 		if err != nil {
 			return cacheData{}, err
 		}
@@ -59,15 +41,21 @@ func cacheUpdate() (cacheData, error) {
 }
 
 func Setup(mux *http.ServeMux) {
-	data, err := cacheUpdate()
+	data, err := cacheUpdate(context.Background())
 	if err != nil {
-		lumber.Error(err, "initial fetch of cache data failed")
+		apis.LogFailure(err, "initial fetch of cache data failed")
 	}
 
 	applemusicCache := cache.New("applemusic", data, err == nil)
+	if err != nil {
+		applemusicCache.RecordError(err)
+	}
 	mux.HandleFunc("GET /applemusic", serveHTTP(applemusicCache))
 	mux.HandleFunc("GET /applemusic/playlists/{id}", playlistEndpoint(applemusicCache))
-	go applemusicCache.UpdatePeriodically(cacheUpdate, 30*time.Second)
+	go applemusicCache.UpdatePeriodically(
+		cacheUpdate,
+		func() time.Duration { return config.Get().AppleMusic.Interval },
+	)
 	lumber.Done("setup apple music cache")
 }
 
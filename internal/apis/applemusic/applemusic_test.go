@@ -0,0 +1,23 @@
+package applemusic
+
+import (
+	"context"
+	"testing"
+
+	"pkg.mattglei.ch/lcp-2/internal/apis/replay"
+	"pkg.mattglei.ch/lcp-2/internal/config"
+)
+
+func TestCacheUpdate(t *testing.T) {
+	replay.Use(t, "cache_update")
+	// a single playlist keeps the cassette small while still covering pagination
+	conf := config.Get()
+	conf.AppleMusic.Playlists = conf.AppleMusic.Playlists[:1]
+	config.Set(conf)
+
+	data, err := cacheUpdate(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	replay.Golden(t, "cache_update", data)
+}
@@ -1,14 +1,13 @@
 package applemusic
 
 import (
+	"context"
 	"encoding/json"
-	"errors"
 	"fmt"
 	"net/http"
 	"time"
 
 	"github.com/gleich/lumber/v3"
-	"pkg.mattglei.ch/lcp-2/internal/apis"
 	"pkg.mattglei.ch/lcp-2/internal/auth"
 	"pkg.mattglei.ch/lcp-2/internal/cache"
 )
@@ -46,19 +45,18 @@ type playlistResponse struct {
 	} `json:"data"`
 }
 
-func fetchPlaylist(id string) (playlist, error) {
+func fetchPlaylist(ctx context.Context, id string) (playlist, error) {
 	playlistData, err := sendAppleMusicAPIRequest[playlistResponse](
+		ctx,
 		fmt.Sprintf("/v1/me/library/playlists/%s", id),
 	)
 	if err != nil {
-		if !errors.Is(err, apis.WarningError) {
-			lumber.Error(err, "failed to fetch playlist for", id)
-		}
 		return playlist{}, err
 	}
 
 	var totalResponseData []songResponse
 	trackData, err := sendAppleMusicAPIRequest[playlistTracksResponse](
+		ctx,
 		fmt.Sprintf("/v1/me/library/playlists/%s/tracks", id),
 	)
 	if err != nil {
@@ -66,11 +64,8 @@ func fetchPlaylist(id string) (playlist, error) {
 	}
 	totalResponseData = append(totalResponseData, trackData.Data...)
 	for trackData.Next != "" {
-		trackData, err = sendAppleMusicAPIRequest[playlistTracksResponse](trackData.Next)
+		trackData, err = sendAppleMusicAPIRequest[playlistTracksResponse](ctx, trackData.Next)
 		if err != nil {
-			if !errors.Is(err, apis.WarningError) {
-				lumber.Error(err, "failed to paginate through tracks for playlist with id of", id)
-			}
 			return playlist{}, err
 		}
 		totalResponseData = append(totalResponseData, trackData.Data...)
@@ -1,11 +1,20 @@
 package applemusic
 
+import (
+	"context"
+
+	"pkg.mattglei.ch/lcp-2/internal/config"
+)
+
 type recentlyPlayedResponse struct {
 	Data []songResponse `json:"data"`
 }
 
-func fetchRecentlyPlayed() ([]song, error) {
-	response, err := sendAppleMusicAPIRequest[recentlyPlayedResponse]("/v1/me/recent/played/tracks")
+func fetchRecentlyPlayed(ctx context.Context) ([]song, error) {
+	response, err := sendAppleMusicAPIRequest[recentlyPlayedResponse](
+		ctx,
+		"/v1/me/recent/played/tracks",
+	)
 	if err != nil {
 		return []song{}, err
 	}
@@ -25,5 +34,8 @@ func fetchRecentlyPlayed() ([]song, error) {
 		}
 	}
 
-	return uniqueSongs[:10], nil
+	if len(uniqueSongs) > config.Get().AppleMusic.RecentlyPlayed {
+		uniqueSongs = uniqueSongs[:config.Get().AppleMusic.RecentlyPlayed]
+	}
+	return uniqueSongs, nil
 }
@@ -0,0 +1,168 @@
+package apis
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gleich/lumber/v3"
+)
+
+const (
+	// number of consecutive failed requests that trips a breaker
+	breakerThreshold = 3
+	// how long a breaker stays open the first time it trips. Doubles every time the probe request
+	// fails up to maxBreakerCooldown.
+	baseBreakerCooldown = 30 * time.Second
+	maxBreakerCooldown  = 5 * time.Minute
+)
+
+var ErrCircuitOpen = errors.New("circuit breaker open")
+
+type BreakerState string
+
+const (
+	BreakerClosed   BreakerState = "closed"
+	BreakerOpen     BreakerState = "open"
+	BreakerHalfOpen BreakerState = "half_open"
+)
+
+type breaker struct {
+	state     BreakerState
+	failures  int
+	cooldown  time.Duration
+	openUntil time.Time
+	probing   bool
+}
+
+// snapshot of the state of a breaker for a single upstream host
+type BreakerStatus struct {
+	Host      string       `json:"host"`
+	State     BreakerState `json:"state"`
+	Failures  int          `json:"failures"`
+	OpenUntil time.Time    `json:"open_until"`
+}
+
+var breakers = struct {
+	mutex    sync.Mutex
+	breakers map[string]*breaker
+}{breakers: map[string]*breaker{}}
+
+// get the status of the breaker for every upstream host that has been requested
+func Breakers() []BreakerStatus {
+	breakers.mutex.Lock()
+	defer breakers.mutex.Unlock()
+	statuses := []BreakerStatus{}
+	for host, b := range breakers.breakers {
+		statuses = append(statuses, BreakerStatus{
+			Host:      host,
+			State:     b.state,
+			Failures:  b.failures,
+			OpenUntil: b.openUntil,
+		})
+	}
+	slices.SortFunc(statuses, func(a, b BreakerStatus) int { return strings.Compare(a.Host, b.Host) })
+	return statuses
+}
+
+// transport that stops sending requests to an upstream host after it fails repeatedly. Once the
+// cooldown has passed a single probe request is let through to check if the host has recovered.
+type breakerTransport struct {
+	base http.RoundTripper
+}
+
+func (t *breakerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	host := req.URL.Host
+	err := allow(host)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := t.base.RoundTrip(req)
+	if err != nil && (req.Context().Err() != nil || errors.Is(err, ErrRateLimited)) {
+		// cancelled requests and ones that the rate limit stopped say nothing about the host
+		release(host)
+		return resp, err
+	}
+	failed := err != nil ||
+		(resp != nil && (resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests))
+	record(host, failed)
+	return resp, err
+}
+
+// check if a request to a host is allowed through its breaker
+func allow(host string) error {
+	breakers.mutex.Lock()
+	defer breakers.mutex.Unlock()
+
+	b, ok := breakers.breakers[host]
+	if !ok {
+		b = &breaker{state: BreakerClosed, cooldown: baseBreakerCooldown}
+		breakers.breakers[host] = b
+	}
+
+	switch b.state {
+	case BreakerOpen:
+		if time.Now().Before(b.openUntil) {
+			return fmt.Errorf(
+				"%w for %s until %s",
+				ErrCircuitOpen,
+				host,
+				b.openUntil.Format(time.RFC3339),
+			)
+		}
+		b.state = BreakerHalfOpen
+		b.probing = true
+	case BreakerHalfOpen:
+		if b.probing {
+			return fmt.Errorf("%w for %s while probe request is in flight", ErrCircuitOpen, host)
+		}
+		b.probing = true
+	}
+	return nil
+}
+
+// release a host's breaker after a request that doesn't count as either a success or a failure,
+// like one that was cancelled, so that the next request can be the probe if this one was
+func release(host string) {
+	breakers.mutex.Lock()
+	defer breakers.mutex.Unlock()
+
+	b := breakers.breakers[host]
+	if b.state == BreakerHalfOpen {
+		b.probing = false
+	}
+}
+
+// record the result of a request that was allowed through a host's breaker
+func record(host string, failed bool) {
+	breakers.mutex.Lock()
+	defer breakers.mutex.Unlock()
+
+	b := breakers.breakers[host]
+	if !failed {
+		b.state = BreakerClosed
+		b.failures = 0
+		b.cooldown = baseBreakerCooldown
+		b.probing = false
+		return
+	}
+
+	b.failures++
+	switch {
+	case b.state == BreakerHalfOpen:
+		b.cooldown = min(b.cooldown*2, maxBreakerCooldown)
+		fallthrough
+	case b.failures >= breakerThreshold:
+		if b.state != BreakerOpen {
+			lumber.Warning("circuit breaker opened for", host, "for", b.cooldown)
+		}
+		b.state = BreakerOpen
+		b.openUntil = time.Now().Add(b.cooldown)
+		b.probing = false
+	}
+}
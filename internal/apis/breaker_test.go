@@ -0,0 +1,71 @@
+package apis
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func TestBreakerIgnoresCancelledAndRateLimitedRequests(t *testing.T) {
+	const host = "breaker.test"
+	t.Cleanup(func() {
+		breakers.mutex.Lock()
+		delete(breakers.breakers, host)
+		breakers.mutex.Unlock()
+	})
+
+	var result error
+	transport := &breakerTransport{base: roundTripFunc(func(*http.Request) (*http.Response, error) {
+		if result != nil {
+			return nil, result
+		}
+		return &http.Response{StatusCode: http.StatusServiceUnavailable, Body: http.NoBody}, nil
+	})}
+	send := func(ctx context.Context) error {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://"+host, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = transport.RoundTrip(req)
+		return err
+	}
+
+	for range breakerThreshold {
+		_ = send(context.Background())
+	}
+	breakers.mutex.Lock()
+	b := breakers.breakers[host]
+	if b.state != BreakerOpen {
+		t.Fatalf("breaker is %s after %d failures", b.state, breakerThreshold)
+	}
+	b.openUntil = time.Now()
+	cooldown := b.cooldown
+	breakers.mutex.Unlock()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	result = context.Canceled
+	_ = send(ctx)
+	result = ErrRateLimited
+	err := send(context.Background())
+	if errors.Is(err, ErrCircuitOpen) {
+		t.Fatal("cancelled probe didn't let the next request probe")
+	}
+
+	breakers.mutex.Lock()
+	defer breakers.mutex.Unlock()
+	if b.state != BreakerHalfOpen || b.failures != breakerThreshold || b.cooldown != cooldown {
+		t.Errorf("breaker changed without a response: %+v", b)
+	}
+	if b.probing {
+		t.Error("breaker is still waiting on a probe")
+	}
+}
@@ -0,0 +1,226 @@
+package apis
+
+import (
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"pkg.mattglei.ch/lcp-2/internal/config"
+	"pkg.mattglei.ch/lcp-2/internal/metrics"
+)
+
+const (
+	maxAttempts   = 3
+	baseBackoff   = 500 * time.Millisecond
+	maxRetryAfter = 30 * time.Second
+)
+
+// transport used for every upstream request. It traces each request without the secrets in its
+// URL, serves responses that rarely change from the on disk response cache, makes conditional
+// requests for responses that have validators, has connect and response timeouts, retries
+// idempotent requests that fail in a way that is worth retrying, and stops sending requests to
+// hosts that keep failing.
+var Transport http.RoundTripper = newTransport()
+
+// client used for every upstream request
+var Client = &http.Client{Transport: Transport, Timeout: 2 * time.Minute}
+
+func newTransport() http.RoundTripper {
+	return otelhttp.NewTransport(
+		&redactSpanTransport{
+			base: &responseCacheTransport{
+				base: &conditionalTransport{
+					base: &breakerTransport{
+						base: &retryTransport{
+							base: &rateLimitTransport{
+								base: &metricsTransport{base: newBaseTransport()},
+							},
+						},
+					},
+				},
+			},
+		},
+		otelhttp.WithSpanNameFormatter(func(_ string, r *http.Request) string {
+			return r.Method + " " + UpstreamName(r.URL)
+		}),
+	)
+}
+
+// join a base URL from the config with a path
+func JoinURL(base, path string) string {
+	return strings.TrimRight(base, "/") + "/" + strings.TrimLeft(path, "/")
+}
+
+// split a URL into the config name of the upstream it belongs to and the rest of the URL after the
+// upstream's base URL. ok is false if the URL isn't under any configured base URL.
+func splitUpstream(u *url.URL) (name string, rest string, ok bool) {
+	upstreams := config.Get().Upstreams
+	bases := []struct{ name, base string }{
+		{"github", upstreams.GitHub},
+		{"strava", upstreams.Strava},
+		{"mapbox", upstreams.Mapbox},
+		{"steam", upstreams.Steam},
+		{"steam_assets", upstreams.SteamAssets},
+		{"applemusic", upstreams.AppleMusic},
+	}
+	full := u.String()
+	longest := 0
+	for _, b := range bases {
+		base := strings.TrimRight(b.base, "/")
+		if base != "" && len(base) > longest && strings.HasPrefix(full, base) {
+			name, rest, longest = b.name, strings.TrimPrefix(full, base), len(base)
+		}
+	}
+	return name, rest, name != ""
+}
+
+func newBaseTransport() *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = (&net.Dialer{
+		Timeout:   10 * time.Second,
+		KeepAlive: 30 * time.Second,
+	}).DialContext
+	transport.TLSHandshakeTimeout = 10 * time.Second
+	transport.ResponseHeaderTimeout = 30 * time.Second
+	return transport
+}
+
+type retryTransport struct {
+	base http.RoundTripper
+}
+
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !isIdempotent(req) {
+		return t.base.RoundTrip(req)
+	}
+
+	attemptReq := req
+	for attempt := 1; ; attempt++ {
+		if attempt > 1 && req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			attemptReq = req.Clone(req.Context())
+			attemptReq.Body = body
+		}
+
+		resp, err := t.base.RoundTrip(attemptReq)
+		if attempt == maxAttempts || !shouldRetry(req, resp, err) {
+			return resp, err
+		}
+
+		wait := baseBackoff << (attempt - 1)
+		if resp != nil {
+			retryAfter, ok := parseRetryAfter(resp.Header.Get("Retry-After"))
+			if ok && retryAfter > maxRetryAfter {
+				return resp, nil
+			}
+			if ok {
+				wait = retryAfter
+			}
+			_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+			resp.Body.Close()
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-req.Context().Done():
+			timer.Stop()
+			return nil, req.Context().Err()
+		case <-timer.C:
+		}
+	}
+}
+
+func isIdempotent(req *http.Request) bool {
+	if req.Body != nil && req.Body != http.NoBody && req.GetBody == nil {
+		return false
+	}
+	switch req.Method {
+	case "", http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return req.Header.Get("Idempotency-Key") != ""
+}
+
+func shouldRetry(req *http.Request, resp *http.Response, err error) bool {
+	if err != nil {
+		return req.Context().Err() == nil && !errors.Is(err, ErrRateLimited)
+	}
+	switch resp.StatusCode {
+	case http.StatusRequestTimeout,
+		http.StatusTooManyRequests,
+		http.StatusInternalServerError,
+		http.StatusBadGateway,
+		http.StatusServiceUnavailable,
+		http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// parse a Retry-After header which is either a number of seconds or an HTTP date
+func parseRetryAfter(value string) (time.Duration, bool) {
+	if value == "" {
+		return 0, false
+	}
+	seconds, err := strconv.Atoi(value)
+	if err == nil {
+		return max(time.Duration(seconds)*time.Second, 0), true
+	}
+	date, err := http.ParseTime(value)
+	if err == nil {
+		return max(time.Until(date), 0), true
+	}
+	return 0, false
+}
+
+// transport that counts every request that is actually sent upstream, including retries
+type metricsTransport struct {
+	base http.RoundTripper
+}
+
+func (t *metricsTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.base.RoundTrip(req)
+	status := 0
+	if resp != nil {
+		status = resp.StatusCode
+	}
+	metrics.ObserveUpstreamRequest(req.URL.Host, status)
+	return resp, err
+}
+
+// span attributes that otelhttp records the full URL of a request in, depending on which semantic
+// conventions it is using
+var urlAttributes = []attribute.Key{"http.url", "url.full"}
+
+// transport that replaces the URL that otelhttp recorded on the request's span with a redacted one.
+// otelhttp only strips the userinfo so the keys and tokens that some upstreams take in the query
+// string would otherwise be exported with every span.
+type redactSpanTransport struct {
+	base http.RoundTripper
+}
+
+func (t *redactSpanTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	span := trace.SpanFromContext(req.Context())
+	recorded, ok := span.(interface{ Attributes() []attribute.KeyValue })
+	if ok {
+		redacted := RedactURL(req.URL)
+		for _, attr := range recorded.Attributes() {
+			if slices.Contains(urlAttributes, attr.Key) {
+				span.SetAttributes(attr.Key.String(redacted))
+			}
+		}
+	}
+	return t.base.RoundTrip(req)
+}
@@ -0,0 +1,80 @@
+package apis
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"pkg.mattglei.ch/lcp-2/internal/config"
+)
+
+func TestTransportRedactsSpanURLs(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	prevConfig := config.Get()
+	prevProvider := otel.GetTracerProvider()
+	config.Set(config.Defaults())
+	t.Cleanup(func() {
+		config.Set(prevConfig)
+		otel.SetTracerProvider(prevProvider)
+	})
+
+	query := url.Values{}
+	for _, param := range redactedParams {
+		query.Set(param, "secret-"+param)
+	}
+
+	// otelhttp records the URL as http.url by default and also as url.full with http/dup
+	for _, conventions := range []string{"", "http/dup"} {
+		t.Run("conventions="+conventions, func(t *testing.T) {
+			t.Setenv("OTEL_SEMCONV_STABILITY_OPT_IN", conventions)
+			recorder := tracetest.NewSpanRecorder()
+			otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+
+			req, err := http.NewRequestWithContext(
+				context.Background(),
+				http.MethodPost,
+				JoinURL(server.URL, "oauth/token?"+query.Encode()),
+				nil,
+			)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// otelhttp reads the conventions when the transport is created
+			resp, err := (&http.Client{Transport: newTransport()}).Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+
+			spans := recorder.Ended()
+			if len(spans) != 1 {
+				t.Fatalf("expected one span, got %d", len(spans))
+			}
+			urls := 0
+			for _, attr := range spans[0].Attributes() {
+				value := attr.Value.Emit()
+				for _, param := range redactedParams {
+					if strings.Contains(value, "secret-"+param) {
+						t.Errorf("span attribute %s has the %s param: %s", attr.Key, param, value)
+					}
+				}
+				if attr.Key == "http.url" || attr.Key == "url.full" {
+					urls++
+				}
+			}
+			if urls == 0 {
+				t.Error("span doesn't have the redacted URL")
+			}
+		})
+	}
+}
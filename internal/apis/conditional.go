@@ -0,0 +1,144 @@
+package apis
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	// max number of responses that are kept around to be reused when an upstream returns a 304
+	maxValidatedResponses = 512
+	// responses bigger than this aren't kept
+	maxValidatedBodySize = 4 << 20
+)
+
+// last response for a URL along with the validators needed to make a conditional request for it
+type validatedResponse struct {
+	etag         string
+	lastModified string
+	header       http.Header
+	body         []byte
+	used         time.Time
+}
+
+var validated = struct {
+	mutex     sync.Mutex
+	responses map[string]*validatedResponse
+}{responses: map[string]*validatedResponse{}}
+
+// transport that stores the ETag and Last-Modified validators for GET responses and sends them
+// with the next request for the same URL. When the upstream replies with a 304 the stored body is
+// returned as a 200 so callers decode the same data without it being downloaded again.
+type conditionalTransport struct {
+	base http.RoundTripper
+}
+
+func (t *conditionalTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Method != http.MethodGet ||
+		req.Header.Get("If-None-Match") != "" ||
+		req.Header.Get("If-Modified-Since") != "" ||
+		req.Header.Get("Range") != "" {
+		return t.base.RoundTrip(req)
+	}
+
+	key := req.URL.String()
+	stored := loadValidated(key)
+	if stored != nil {
+		// round trippers shouldn't modify the request they are given
+		req = req.Clone(req.Context())
+		if stored.etag != "" {
+			req.Header.Set("If-None-Match", stored.etag)
+		}
+		if stored.lastModified != "" {
+			req.Header.Set("If-Modified-Since", stored.lastModified)
+		}
+	}
+
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode == http.StatusNotModified && stored != nil {
+		_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+		resp.Body.Close()
+		header := stored.header.Clone()
+		for name, values := range resp.Header {
+			header[name] = values
+		}
+		return &http.Response{
+			Status:        "200 OK",
+			StatusCode:    http.StatusOK,
+			Proto:         resp.Proto,
+			ProtoMajor:    resp.ProtoMajor,
+			ProtoMinor:    resp.ProtoMinor,
+			Header:        header,
+			Body:          io.NopCloser(bytes.NewReader(stored.body)),
+			ContentLength: int64(len(stored.body)),
+			Request:       req,
+		}, nil
+	}
+
+	etag, lastModified := resp.Header.Get("ETag"), resp.Header.Get("Last-Modified")
+	if resp.StatusCode != http.StatusOK || (etag == "" && lastModified == "") ||
+		resp.ContentLength > maxValidatedBodySize {
+		return resp, nil
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxValidatedBodySize+1))
+	if err != nil {
+		resp.Body.Close()
+		return nil, err
+	}
+	if len(body) > maxValidatedBodySize {
+		// too big to keep so the rest of the body is streamed to the caller as normal
+		resp.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(body), resp.Body), resp.Body}
+		return resp, nil
+	}
+	resp.Body.Close()
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	storeValidated(key, &validatedResponse{
+		etag:         etag,
+		lastModified: lastModified,
+		header:       resp.Header.Clone(),
+		body:         body,
+	})
+	return resp, nil
+}
+
+func loadValidated(key string) *validatedResponse {
+	validated.mutex.Lock()
+	defer validated.mutex.Unlock()
+	stored, ok := validated.responses[key]
+	if !ok {
+		return nil
+	}
+	stored.used = time.Now()
+	return stored
+}
+
+func storeValidated(key string, response *validatedResponse) {
+	validated.mutex.Lock()
+	defer validated.mutex.Unlock()
+	response.used = time.Now()
+	validated.responses[key] = response
+	if len(validated.responses) <= maxValidatedResponses {
+		return
+	}
+	// evict the least recently used response
+	var (
+		oldestKey string
+		oldest    time.Time
+	)
+	for k, r := range validated.responses {
+		if oldestKey == "" || r.used.Before(oldest) {
+			oldestKey, oldest = k, r.used
+		}
+	}
+	delete(validated.responses, oldestKey)
+}
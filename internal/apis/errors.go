@@ -0,0 +1,172 @@
+package apis
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// classification of an upstream error that decides how callers should react to it
+type ErrorKind string
+
+const (
+	// temporary failure such as a 503, 429, or connection reset. Trying again later should work.
+	Retriable ErrorKind = "retriable"
+	// failure that won't go away by trying again such as a 404 or an unexpected response body
+	Permanent ErrorKind = "permanent"
+	// credentials were rejected by the upstream and need to be rotated
+	AuthExpired ErrorKind = "auth_expired"
+)
+
+const snippetLength = 256
+
+// query parameters that are never included in errors or logs
+var redactedParams = []string{
+	"access_token",
+	"client_secret",
+	"code",
+	"key",
+	"refresh_token",
+	"token",
+}
+
+// error from a request to an upstream API. Upstream errors are logged once where the request
+// failed so anything that they're returned to shouldn't log them again.
+type UpstreamError struct {
+	Upstream   string
+	URL        string // URL with secrets redacted. Empty if the error didn't come from a request.
+	StatusCode int    // zero if no response was received
+	Snippet    string // start of the response body
+	Kind       ErrorKind
+	Err        error
+}
+
+func (e *UpstreamError) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s upstream error from %s", e.Kind, e.Upstream)
+	if e.StatusCode != 0 {
+		fmt.Fprintf(&b, ": %d %s", e.StatusCode, http.StatusText(e.StatusCode))
+	}
+	if e.Err != nil {
+		fmt.Fprintf(&b, ": %v", e.Err)
+	}
+	if e.URL != "" {
+		fmt.Fprintf(&b, " (%s)", e.URL)
+	}
+	if e.Snippet != "" {
+		fmt.Fprintf(&b, " body: %s", e.Snippet)
+	}
+	return b.String()
+}
+
+func (e *UpstreamError) Unwrap() error {
+	return e.Err
+}
+
+// get the kind of the given error. Errors that aren't an UpstreamError are permanent.
+func KindOf(err error) ErrorKind {
+	var upstreamErr *UpstreamError
+	if errors.As(err, &upstreamErr) {
+		return upstreamErr.Kind
+	}
+	return Permanent
+}
+
+func IsRetriable(err error) bool {
+	return err != nil && KindOf(err) == Retriable
+}
+
+func IsAuthExpired(err error) bool {
+	return err != nil && KindOf(err) == AuthExpired
+}
+
+// create an UpstreamError for a request that got a response with a non-successful status code
+func NewStatusError(req *http.Request, statusCode int, body []byte) *UpstreamError {
+	kind := Permanent
+	switch {
+	case statusCode == http.StatusUnauthorized || statusCode == http.StatusForbidden:
+		kind = AuthExpired
+	case statusCode == http.StatusRequestTimeout ||
+		statusCode == http.StatusTooEarly ||
+		statusCode == http.StatusTooManyRequests ||
+		statusCode >= 500:
+		kind = Retriable
+	}
+	return &UpstreamError{
+		Upstream:   UpstreamName(req.URL),
+		URL:        RedactURL(req.URL),
+		StatusCode: statusCode,
+		Snippet:    snippet(body),
+		Kind:       kind,
+	}
+}
+
+// create an UpstreamError for a request that failed without a usable response
+func NewRequestError(req *http.Request, kind ErrorKind, err error) *UpstreamError {
+	return &UpstreamError{
+		Upstream: UpstreamName(req.URL),
+		URL:      RedactURL(req.URL),
+		Kind:     kind,
+		Err:      err,
+	}
+}
+
+// create an UpstreamError for a request that couldn't be sent or didn't have its whole response
+// read, which is retriable if the problem is temporary
+func NewSendError(req *http.Request, err error) *UpstreamError {
+	kind := Permanent
+	if isTransientError(err) {
+		kind = Retriable
+	}
+	return NewRequestError(req, kind, err)
+}
+
+// get the name of the upstream that a URL belongs to based on the configured base URLs, falling
+// back to the host of the URL
+func UpstreamName(u *url.URL) string {
+	if name, _, ok := splitUpstream(u); ok {
+		if name == "steam_assets" {
+			return "steam"
+		}
+		return name
+	}
+
+	host := u.Hostname()
+	switch {
+	case strings.HasSuffix(host, "strava.com"):
+		return "strava"
+	case strings.HasSuffix(host, "music.apple.com"):
+		return "applemusic"
+	case strings.HasSuffix(host, "steampowered.com") || strings.HasSuffix(host, "steamstatic.com"):
+		return "steam"
+	case strings.HasSuffix(host, "github.com"):
+		return "github"
+	case strings.HasSuffix(host, "mapbox.com"):
+		return "mapbox"
+	}
+	return host
+}
+
+// get a string version of a URL with any secrets in the query parameters removed
+func RedactURL(u *url.URL) string {
+	redacted := *u
+	redacted.User = nil
+	query := redacted.Query()
+	for _, param := range redactedParams {
+		if query.Has(param) {
+			query.Set(param, "REDACTED")
+		}
+	}
+	redacted.RawQuery = query.Encode()
+	return redacted.String()
+}
+
+func snippet(body []byte) string {
+	s := strings.TrimSpace(string(body))
+	if len(s) > snippetLength {
+		s = s[:snippetLength] + "..."
+	}
+	return s
+}
@@ -2,33 +2,74 @@ package github
 
 import (
 	"context"
+	"io"
 	"net/http"
 	"time"
 
 	"github.com/gleich/lumber/v3"
 	"github.com/shurcooL/githubv4"
 	"golang.org/x/oauth2"
+	"pkg.mattglei.ch/lcp-2/internal/apis"
 	"pkg.mattglei.ch/lcp-2/internal/cache"
+	"pkg.mattglei.ch/lcp-2/internal/config"
 	"pkg.mattglei.ch/lcp-2/internal/secrets"
 )
 
-func Setup(mux *http.ServeMux) {
-	githubTokenSource := oauth2.StaticTokenSource(
-		&oauth2.Token{AccessToken: secrets.SECRETS.GitHubAccessToken},
-	)
-	githubHttpClient := oauth2.NewClient(context.Background(), githubTokenSource)
-	githubClient := githubv4.NewClient(githubHttpClient)
+// token source that always returns the latest access token so it can be rotated without a restart
+type tokenSource struct{}
 
-	pinnedRepos, err := fetchPinnedRepos(githubClient)
+func (tokenSource) Token() (*oauth2.Token, error) {
+	return &oauth2.Token{AccessToken: secrets.Get().GitHubAccessToken}, nil
+}
+
+// transport that turns failed requests into upstream errors as githubv4 only has the status of a
+// response in the message of the error that it returns
+type errorTransport struct {
+	base http.RoundTripper
+}
+
+func (t *errorTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.base.RoundTrip(req)
 	if err != nil {
-		lumber.Error(err, "fetching initial pinned repos failed")
+		upstreamErr := apis.NewSendError(req, err)
+		apis.LogUpstreamError(upstreamErr)
+		return nil, upstreamErr
+	}
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		resp.Body.Close()
+		upstreamErr := apis.NewStatusError(req, resp.StatusCode, body)
+		apis.LogUpstreamError(upstreamErr)
+		return nil, upstreamErr
+	}
+	return resp, nil
+}
+
+func newClient() *githubv4.Client {
+	httpClient := &http.Client{
+		Transport: &errorTransport{
+			base: &oauth2.Transport{Source: tokenSource{}, Base: apis.Transport},
+		},
+		Timeout: apis.Client.Timeout,
+	}
+	return githubv4.NewEnterpriseClient(config.Get().Upstreams.GitHub, httpClient)
+}
+
+func Setup(mux *http.ServeMux) {
+	githubClient := newClient()
+	pinnedRepos, err := fetchPinnedRepos(context.Background(), githubClient)
+	if err != nil {
+		apis.LogFailure(err, "fetching initial pinned repos failed")
 	}
 
 	githubCache := cache.New("github", pinnedRepos, err == nil)
+	if err != nil {
+		githubCache.RecordError(err)
+	}
 	mux.HandleFunc("GET /github", githubCache.ServeHTTP)
 	go githubCache.UpdatePeriodically(
-		func() ([]repository, error) { return fetchPinnedRepos(githubClient) },
-		1*time.Minute,
+		func(ctx context.Context) ([]repository, error) { return fetchPinnedRepos(ctx, githubClient) },
+		func() time.Duration { return config.Get().GitHub.Interval },
 	)
 	lumber.Done("setup github cache")
 }
@@ -2,11 +2,13 @@ package github
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"time"
 
-	"github.com/gleich/lumber/v3"
 	"github.com/shurcooL/githubv4"
+	"pkg.mattglei.ch/lcp-2/internal/apis"
+	"pkg.mattglei.ch/lcp-2/internal/config"
 )
 
 type pinnedItemsQuery struct {
@@ -29,7 +31,12 @@ type pinnedItemsQuery struct {
 					URL         githubv4.URI
 				} `graphql:"... on Repository"`
 			}
-		} `graphql:"pinnedItems(first: 6, types: REPOSITORY)"`
+		} `graphql:"pinnedItems(first: $first, types: REPOSITORY)"`
+	}
+	RateLimit struct {
+		Limit     githubv4.Int
+		Remaining githubv4.Int
+		ResetAt   githubv4.DateTime
 	}
 }
 
@@ -44,13 +51,26 @@ type repository struct {
 	URL           string    `json:"url"`
 }
 
-func fetchPinnedRepos(client *githubv4.Client) ([]repository, error) {
+func fetchPinnedRepos(ctx context.Context, client *githubv4.Client) ([]repository, error) {
 	var query pinnedItemsQuery
-	err := client.Query(context.Background(), &query, nil)
+	variables := map[string]any{"first": githubv4.Int(config.Get().GitHub.PinnedRepos)}
+	err := client.Query(ctx, &query, variables)
 	if err != nil {
-		lumber.Error(err, "querying github's graphql API failed")
-		return nil, err
+		var upstreamErr *apis.UpstreamError
+		if errors.As(err, &upstreamErr) {
+			return nil, err
+		}
+		// errors that graphql responded with or the response not matching the query
+		upstreamErr = &apis.UpstreamError{Upstream: "github", Kind: apis.Permanent, Err: err}
+		apis.LogUpstreamError(upstreamErr)
+		return nil, upstreamErr
 	}
+	apis.RecordRateLimit("github", apis.Window{
+		Name:   "hourly",
+		Limit:  int(query.RateLimit.Limit),
+		Used:   int(query.RateLimit.Limit - query.RateLimit.Remaining),
+		Resets: query.RateLimit.ResetAt.Time,
+	})
 
 	var repositories []repository
 	for _, node := range query.Viewer.PinnedItems.Nodes {
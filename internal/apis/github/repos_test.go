@@ -0,0 +1,39 @@
+package github
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"pkg.mattglei.ch/lcp-2/internal/apis"
+	"pkg.mattglei.ch/lcp-2/internal/apis/replay"
+	"pkg.mattglei.ch/lcp-2/internal/config"
+)
+
+func TestFetchPinnedRepos(t *testing.T) {
+	replay.Use(t, "fetch_pinned_repos")
+
+	repos, err := fetchPinnedRepos(context.Background(), newClient())
+	if err != nil {
+		t.Fatal(err)
+	}
+	replay.Golden(t, "fetch_pinned_repos", repos)
+}
+
+func TestFetchPinnedReposRejectedToken(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, `{"message":"Bad credentials"}`, http.StatusUnauthorized)
+	}))
+	defer server.Close()
+	prevConfig := config.Get()
+	conf := config.Defaults()
+	conf.Upstreams.GitHub = server.URL
+	config.Set(conf)
+	t.Cleanup(func() { config.Set(prevConfig) })
+
+	_, err := fetchPinnedRepos(context.Background(), newClient())
+	if !apis.IsAuthExpired(err) {
+		t.Errorf("expected an auth_expired error, got %v", err)
+	}
+}
@@ -0,0 +1,194 @@
+package apis
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// fraction of a rate limit window that has to be left before a budget is considered low
+const lowBudgetFraction = 0.1
+
+// how long to back off from an upstream that returned a 429 without a Retry-After header
+const defaultThrottle = time.Minute
+
+var ErrRateLimited = errors.New("upstream rate limit exhausted")
+
+// a single rate limit window such as Strava's 15 minute or daily limit
+type Window struct {
+	Name   string    `json:"name"`
+	Limit  int       `json:"limit"`
+	Used   int       `json:"used"`
+	Resets time.Time `json:"resets"`
+}
+
+func (w Window) Remaining() int {
+	return max(w.Limit-w.Used, 0)
+}
+
+// rate limit budget for a single upstream
+type Budget struct {
+	Upstream     string    `json:"upstream"`
+	Windows      []Window  `json:"windows"`
+	BlockedUntil time.Time `json:"blocked_until"`
+	Updated      time.Time `json:"updated"`
+}
+
+// if the budget is exhausted or close to being exhausted. Optional requests should be skipped when
+// the budget is low.
+func (b Budget) Low() bool {
+	now := time.Now()
+	if b.BlockedUntil.After(now) {
+		return true
+	}
+	for _, w := range b.Windows {
+		if w.Resets.After(now) && float64(w.Remaining()) < float64(w.Limit)*lowBudgetFraction {
+			return true
+		}
+	}
+	return false
+}
+
+var budgets = struct {
+	mutex   sync.RWMutex
+	budgets map[string]*Budget
+}{budgets: map[string]*Budget{}}
+
+func budgetFor(upstream string) *Budget {
+	b, ok := budgets.budgets[upstream]
+	if !ok {
+		b = &Budget{Upstream: upstream}
+		budgets.budgets[upstream] = b
+	}
+	return b
+}
+
+// record the current rate limit windows of an upstream
+func RecordRateLimit(upstream string, windows ...Window) {
+	budgets.mutex.Lock()
+	defer budgets.mutex.Unlock()
+	b := budgetFor(upstream)
+	b.Windows = windows
+	b.Updated = time.Now()
+}
+
+// record that an upstream is throttling requests until the given time
+func RecordThrottled(upstream string, until time.Time) {
+	budgets.mutex.Lock()
+	defer budgets.mutex.Unlock()
+	b := budgetFor(upstream)
+	b.BlockedUntil = until
+	b.Updated = time.Now()
+}
+
+// if the rate limit budget for an upstream is low
+func BudgetLow(upstream string) bool {
+	budgets.mutex.RLock()
+	defer budgets.mutex.RUnlock()
+	b, ok := budgets.budgets[upstream]
+	return ok && b.Low()
+}
+
+// get a snapshot of every known rate limit budget
+func Budgets() []Budget {
+	budgets.mutex.RLock()
+	defer budgets.mutex.RUnlock()
+	snapshot := []Budget{}
+	for _, b := range budgets.budgets {
+		c := *b
+		c.Windows = slices.Clone(b.Windows)
+		snapshot = append(snapshot, c)
+	}
+	slices.SortFunc(snapshot, func(a, b Budget) int { return strings.Compare(a.Upstream, b.Upstream) })
+	return snapshot
+}
+
+// transport that records rate limit information from every response and refuses to send requests
+// to upstreams that are currently throttling us
+type rateLimitTransport struct {
+	base http.RoundTripper
+}
+
+func (t *rateLimitTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	upstream := UpstreamName(req.URL)
+	budgets.mutex.RLock()
+	var blockedUntil time.Time
+	if b, ok := budgets.budgets[upstream]; ok {
+		blockedUntil = b.BlockedUntil
+	}
+	budgets.mutex.RUnlock()
+	if blockedUntil.After(time.Now()) {
+		return nil, fmt.Errorf(
+			"%w for %s until %s",
+			ErrRateLimited,
+			upstream,
+			blockedUntil.Format(time.RFC3339),
+		)
+	}
+
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		return resp, err
+	}
+
+	if upstream == "strava" {
+		recordStravaRateLimit(resp.Header)
+	}
+	if resp.StatusCode == http.StatusTooManyRequests {
+		wait, ok := parseRetryAfter(resp.Header.Get("Retry-After"))
+		if !ok {
+			wait = defaultThrottle
+		}
+		RecordThrottled(upstream, time.Now().Add(wait))
+	}
+	return resp, nil
+}
+
+// parse Strava's rate limit headers. Each header has two comma separated values: the first for the
+// 15 minute window and the second for the daily window. The stricter read limits are used when
+// they're included.
+func recordStravaRateLimit(header http.Header) {
+	limitHeader, usageHeader := "X-ReadRateLimit-Limit", "X-ReadRateLimit-Usage"
+	if header.Get(limitHeader) == "" {
+		limitHeader, usageHeader = "X-RateLimit-Limit", "X-RateLimit-Usage"
+	}
+	limits := parseIntList(header.Get(limitHeader))
+	usages := parseIntList(header.Get(usageHeader))
+	if len(limits) != 2 || len(usages) != 2 {
+		return
+	}
+
+	now := time.Now().UTC()
+	RecordRateLimit(
+		"strava",
+		Window{
+			Name:   "15m",
+			Limit:  limits[0],
+			Used:   usages[0],
+			Resets: now.Truncate(15 * time.Minute).Add(15 * time.Minute),
+		},
+		Window{
+			Name:   "daily",
+			Limit:  limits[1],
+			Used:   usages[1],
+			Resets: time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, time.UTC),
+		},
+	)
+}
+
+func parseIntList(value string) []int {
+	var values []int
+	for _, part := range strings.Split(value, ",") {
+		v, err := strconv.Atoi(strings.TrimSpace(part))
+		if err != nil {
+			return nil
+		}
+		values = append(values, v)
+	}
+	return values
+}
@@ -0,0 +1,248 @@
+package replay
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"pkg.mattglei.ch/lcp-2/internal/apis"
+)
+
+type Mode int
+
+const (
+	// serve responses from the cassette without touching the network
+	Replay Mode = iota
+	// send requests upstream and write the exchanges to the cassette
+	Record
+)
+
+// response headers that are kept in a cassette. Everything else is dropped so nothing sensitive
+// (cookies, request IDs, etc) ends up in the repo.
+var keptHeaders = []string{
+	"Content-Type",
+	"ETag",
+	"Last-Modified",
+	"Location",
+	"Retry-After",
+	"X-RateLimit-Limit",
+	"X-RateLimit-Usage",
+	"X-ReadRateLimit-Limit",
+	"X-ReadRateLimit-Usage",
+}
+
+var tokenFieldRegex = regexp.MustCompile(`"(access_token|refresh_token)"\s*:\s*"[^"]*"`)
+
+// single recorded request and its response
+type Interaction struct {
+	Method       string      `json:"method"`
+	URL          string      `json:"url"`
+	Status       int         `json:"status"`
+	Header       http.Header `json:"header,omitempty"`
+	Body         string      `json:"body"`
+	BodyEncoding string      `json:"body_encoding,omitempty"`
+}
+
+type cassette struct {
+	Interactions []Interaction `json:"interactions"`
+}
+
+// transport that records upstream exchanges to a cassette file or replays them from one. Requests
+// are matched on their method and URL after secrets have been scrubbed and the configured upstream
+// base URLs have been replaced with their names, so a cassette recorded against the real upstreams
+// or the mock upstreams server can be replayed with any config.
+type Transport struct {
+	mode    Mode
+	path    string
+	base    http.RoundTripper
+	bases   map[string]string
+	secrets []string
+
+	mutex        sync.Mutex
+	interactions []Interaction
+	served       map[string]int
+}
+
+// create a transport for the cassette at path. bases maps upstream names to their base URLs and
+// secrets are values that are scrubbed from everything that is recorded. base is only used when
+// recording.
+func New(
+	path string,
+	mode Mode,
+	base http.RoundTripper,
+	bases map[string]string,
+	secrets []string,
+) (*Transport, error) {
+	t := &Transport{
+		mode:    mode,
+		path:    path,
+		base:    base,
+		bases:   bases,
+		secrets: secrets,
+		served:  map[string]int{},
+	}
+	if mode == Record {
+		return t, nil
+	}
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("%w failed to read cassette (record it with LCP_RECORD=1)", err)
+	}
+	var c cassette
+	err = json.Unmarshal(b, &c)
+	if err != nil {
+		return nil, fmt.Errorf("%w failed to parse cassette %s", err, path)
+	}
+	t.interactions = c.Interactions
+	return t, nil
+}
+
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	key := t.normalize(req.URL)
+	if t.mode == Record {
+		return t.record(req, key)
+	}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	// identical requests are served in the order they were recorded with the last one repeating
+	var matches []Interaction
+	for _, i := range t.interactions {
+		if i.Method == req.Method && i.URL == key {
+			matches = append(matches, i)
+		}
+	}
+	if len(matches) == 0 {
+		return nil, fmt.Errorf("no recorded response in %s for %s %s", t.path, req.Method, key)
+	}
+	n := t.served[req.Method+" "+key]
+	t.served[req.Method+" "+key]++
+	i := matches[min(n, len(matches)-1)]
+
+	body := []byte(i.Body)
+	if i.BodyEncoding == "base64" {
+		var err error
+		body, err = base64.StdEncoding.DecodeString(i.Body)
+		if err != nil {
+			return nil, fmt.Errorf("%w failed to decode recorded body for %s", err, key)
+		}
+	}
+	if req.Body != nil {
+		_, _ = io.Copy(io.Discard, req.Body)
+		req.Body.Close()
+	}
+	header := i.Header.Clone()
+	if header == nil {
+		header = http.Header{}
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", i.Status, http.StatusText(i.Status)),
+		StatusCode:    i.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}, nil
+}
+
+func (t *Transport) record(req *http.Request, key string) (*http.Response, error) {
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+
+	i := Interaction{
+		Method: req.Method,
+		URL:    key,
+		Status: resp.StatusCode,
+		Header: http.Header{},
+	}
+	for _, name := range keptHeaders {
+		if values := resp.Header.Values(name); len(values) > 0 {
+			for _, value := range values {
+				i.Header.Add(name, t.scrub(value))
+			}
+		}
+	}
+	if utf8.Valid(body) {
+		i.Body = tokenFieldRegex.ReplaceAllString(t.scrub(string(body)), `"$1":"REDACTED"`)
+	} else {
+		i.Body = base64.StdEncoding.EncodeToString(body)
+		i.BodyEncoding = "base64"
+	}
+
+	t.mutex.Lock()
+	t.interactions = append(t.interactions, i)
+	t.mutex.Unlock()
+	return resp, nil
+}
+
+// write the recorded interactions to the cassette. Does nothing when replaying.
+func (t *Transport) Save() error {
+	if t.mode != Record {
+		return nil
+	}
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	b, err := json.MarshalIndent(cassette{Interactions: t.interactions}, "", "  ")
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(t.path), 0755)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(t.path, append(b, '\n'), 0644)
+}
+
+// turn a request URL into the form it is stored in a cassette
+func (t *Transport) normalize(u *url.URL) string {
+	// base URLs are replaced before scrubbing as they can contain secrets like the minio endpoint
+	normalized := apis.RedactURL(u)
+
+	names := make([]string, 0, len(t.bases))
+	for name := range t.bases {
+		names = append(names, name)
+	}
+	// longest base first so nested base URLs get the most specific name
+	slices.SortFunc(names, func(a, b string) int { return len(t.bases[b]) - len(t.bases[a]) })
+	for _, name := range names {
+		base := strings.TrimRight(t.bases[name], "/")
+		rest, found := strings.CutPrefix(normalized, base)
+		if base != "" && found && (rest == "" || rest[0] == '/' || rest[0] == '?') {
+			return "{" + name + "}" + t.scrub(rest)
+		}
+	}
+	return t.scrub(normalized)
+}
+
+func (t *Transport) scrub(s string) string {
+	for _, secret := range t.secrets {
+		if len(secret) >= 6 {
+			s = strings.ReplaceAll(s, secret, "REDACTED")
+			s = strings.ReplaceAll(s, url.QueryEscape(secret), "REDACTED")
+		}
+	}
+	return s
+}
@@ -0,0 +1,154 @@
+package replay
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"pkg.mattglei.ch/lcp-2/internal/apis"
+	"pkg.mattglei.ch/lcp-2/internal/config"
+	"pkg.mattglei.ch/lcp-2/internal/secrets"
+)
+
+var update = flag.Bool("update", false, "rewrite golden files with the current output")
+
+// set up a test to replay the upstream exchanges in testdata/<name>.json with the default config
+// and placeholder secrets. When LCP_RECORD is set the config and secrets are loaded like they are
+// in production instead and the exchanges are recorded to the cassette once the test finishes.
+func Use(t testing.TB, name string) *Transport {
+	t.Helper()
+
+	var (
+		prevConfig          = config.Get()
+		prevSecrets         = secrets.Get()
+		prevTransport       = apis.Transport
+		prevClientTransport = apis.Client.Transport
+	)
+	mode := Replay
+	if os.Getenv("LCP_RECORD") != "" {
+		mode = Record
+		conf, err := config.Read(config.Path())
+		if err != nil {
+			t.Fatal(err, "failed to read config for recording")
+		}
+		config.Set(conf)
+		s, err := secrets.Read()
+		if err != nil {
+			t.Fatal(err, "failed to read secrets for recording")
+		}
+		secrets.Set(s)
+	} else {
+		config.Set(config.Defaults())
+		secrets.Set(placeholderSecrets(t))
+	}
+
+	transport, err := New(
+		filepath.Join("testdata", name+".json"),
+		mode,
+		prevTransport,
+		upstreamBases(),
+		secretValues(secrets.Get()),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	apis.Transport = transport
+	apis.Client.Transport = transport
+
+	t.Cleanup(func() {
+		err := transport.Save()
+		if err != nil {
+			t.Error(err, "failed to save cassette")
+		}
+		apis.Transport = prevTransport
+		apis.Client.Transport = prevClientTransport
+		config.Set(prevConfig)
+		secrets.Set(prevSecrets)
+	})
+	return transport
+}
+
+// compare the JSON encoding of data against testdata/<name>.golden.json. Run the tests with
+// -update to rewrite the golden file. Nothing is compared while recording as the output depends on
+// the config that is being recorded with.
+func Golden(t testing.TB, name string, data any) {
+	t.Helper()
+	if os.Getenv("LCP_RECORD") != "" {
+		return
+	}
+
+	got, err := json.MarshalIndent(data, "", "  ")
+	if err != nil {
+		t.Fatal(err, "failed to encode output")
+	}
+	got = append(got, '\n')
+	path := filepath.Join("testdata", name+".golden.json")
+	if *update {
+		err = os.MkdirAll(filepath.Dir(path), 0755)
+		if err != nil {
+			t.Fatal(err, "failed to create testdata folder")
+		}
+		err = os.WriteFile(path, got, 0644)
+		if err != nil {
+			t.Fatal(err, "failed to write golden file")
+		}
+		return
+	}
+
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err, "failed to read golden file (create it with -update)")
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("output doesn't match %s (run with -update to accept it)\ngot:\n%s", path, got)
+	}
+}
+
+func upstreamBases() map[string]string {
+	upstreams := config.Get().Upstreams
+	minio := "http://" + secrets.Get().MinioEndpoint
+	if config.Get().Strava.MinioSecure {
+		minio = "https://" + secrets.Get().MinioEndpoint
+	}
+	return map[string]string{
+		"github":       upstreams.GitHub,
+		"strava":       upstreams.Strava,
+		"mapbox":       upstreams.Mapbox,
+		"steam":        upstreams.Steam,
+		"steam_assets": upstreams.SteamAssets,
+		"applemusic":   upstreams.AppleMusic,
+		"minio":        minio,
+	}
+}
+
+// secrets with every string set to the value that recorded secrets are scrubbed to so requests
+// built from them match the cassette
+func placeholderSecrets(t testing.TB) secrets.Secrets {
+	var s secrets.Secrets
+	v := reflect.ValueOf(&s).Elem()
+	for i := range v.NumField() {
+		if v.Field(i).Kind() == reflect.String {
+			v.Field(i).SetString("REDACTED")
+		}
+	}
+	s.CacheFolder = t.TempDir()
+	return s
+}
+
+func secretValues(s secrets.Secrets) []string {
+	var values []string
+	v := reflect.ValueOf(s)
+	for i := range v.NumField() {
+		if v.Field(i).Kind() == reflect.String && v.Type().Field(i).Name != "CacheFolder" {
+			values = append(values, v.Field(i).String())
+		}
+	}
+	for _, token := range s.NamedTokens {
+		values = append(values, token)
+	}
+	return values
+}
@@ -6,50 +6,80 @@ import (
 	"io"
 	"net"
 	"net/http"
+	"syscall"
 
 	"github.com/gleich/lumber/v3"
 )
 
-var WarningError = errors.New("Warning error when trying to make request. Ignore error.")
-
 // sends a given http.Request and will unmarshal the JSON from the response body and return that as the given type.
+// Any error returned is an *UpstreamError that has already been logged.
 func SendRequest[T any](req *http.Request) (T, error) {
 	var zeroValue T // to be used as "nil" when returning errors
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := Client.Do(req)
 	if err != nil {
-		lumber.Error(err, "sending request failed")
-		return zeroValue, err
+		upstreamErr := NewSendError(req, err)
+		LogUpstreamError(upstreamErr)
+		return zeroValue, upstreamErr
 	}
 	defer resp.Body.Close()
 
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		if errors.Is(err, io.ErrUnexpectedEOF) {
-			lumber.Warning("Unexpected EOF from", req.URL.String())
-			return zeroValue, WarningError
-		}
-		var netErr *net.OpError
-		if errors.As(err, &netErr) && netErr.Err != nil &&
-			netErr.Err.Error() == "connection reset by peer" {
-			lumber.Warning("TCP connection reset by peer from", req.URL.String())
-			return zeroValue, WarningError
-		}
-
-		lumber.Error(err, "reading response body failed")
-		return zeroValue, err
+		upstreamErr := NewSendError(req, err)
+		upstreamErr.StatusCode = resp.StatusCode
+		LogUpstreamError(upstreamErr)
+		return zeroValue, upstreamErr
 	}
 	if resp.StatusCode != http.StatusOK {
-		lumber.Warning(resp.StatusCode, "returned from", req.URL.String())
-		return zeroValue, WarningError
+		upstreamErr := NewStatusError(req, resp.StatusCode, body)
+		LogUpstreamError(upstreamErr)
+		return zeroValue, upstreamErr
 	}
 
 	var data T
 	err = json.Unmarshal(body, &data)
 	if err != nil {
-		lumber.Error(err, "failed to parse json")
-		lumber.Debug(string(body))
-		return zeroValue, err
+		upstreamErr := NewRequestError(req, Permanent, err)
+		upstreamErr.StatusCode = resp.StatusCode
+		upstreamErr.Snippet = snippet(body)
+		LogUpstreamError(upstreamErr)
+		return zeroValue, upstreamErr
 	}
 
 	return data, nil
 }
+
+// log an upstream error where its request failed. Retriable errors are only warnings.
+func LogUpstreamError(err *UpstreamError) {
+	switch err.Kind {
+	case Retriable:
+		lumber.Warning(err)
+	case AuthExpired:
+		lumber.Error(err, "upstream request failed; credentials need to be rotated")
+	default:
+		lumber.Error(err, "upstream request failed")
+	}
+}
+
+// log an error that stopped something from being fetched unless it's an upstream error, which was
+// already logged when its request failed
+func LogFailure(err error, msg ...any) {
+	var upstreamErr *UpstreamError
+	if !errors.As(err, &upstreamErr) {
+		lumber.Error(err, msg...)
+	}
+}
+
+// if an error from sending a request or reading its body is a temporary problem
+func isTransientError(err error) bool {
+	if errors.Is(err, ErrRateLimited) ||
+		errors.Is(err, ErrCircuitOpen) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, io.EOF) ||
+		errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.ECONNREFUSED) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
@@ -0,0 +1,222 @@
+package apis
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gleich/lumber/v3"
+	"pkg.mattglei.ch/lcp-2/internal/config"
+	"pkg.mattglei.ch/lcp-2/internal/files"
+	"pkg.mattglei.ch/lcp-2/internal/secrets"
+)
+
+// responses bigger than this aren't cached
+const maxCachedBodySize = 4 << 20
+
+// response that is stored on disk by the response cache
+type cachedResponse struct {
+	URL    string      `json:"url"` // redacted and only kept to make the files easier to inspect
+	Status int         `json:"status"`
+	Header http.Header `json:"header"`
+	Body   []byte      `json:"body"`
+	Stored time.Time   `json:"stored"`
+}
+
+func (c cachedResponse) response(req *http.Request) *http.Response {
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", c.Status, http.StatusText(c.Status)),
+		StatusCode:    c.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        c.Header.Clone(),
+		Body:          io.NopCloser(bytes.NewReader(c.Body)),
+		ContentLength: int64(len(c.Body)),
+		Request:       req,
+	}
+}
+
+var pruneResponseCache sync.Once
+
+// transport that serves responses for endpoints matching a response cache rule from disk until
+// their TTL has passed, so they survive restarts. Expired responses are revalidated with the
+// upstream when they have an ETag or Last-Modified header. Found (and not found) responses are
+// cached so missing assets aren't checked for over and over again.
+type responseCacheTransport struct {
+	base http.RoundTripper
+}
+
+func (t *responseCacheTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ttl := responseTTL(req)
+	if ttl == 0 {
+		return t.base.RoundTrip(req)
+	}
+	pruneResponseCache.Do(pruneCachedResponses)
+
+	file := cachedResponsePath(req)
+	cached, err := readCachedResponse(file)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		lumber.Error(err, "failed to read cached response from", file)
+	}
+	hasCached := err == nil
+	if hasCached && time.Since(cached.Stored) < ttl {
+		return cached.response(req), nil
+	}
+
+	if hasCached {
+		// round trippers shouldn't modify the request they are given
+		req = req.Clone(req.Context())
+		if etag := cached.Header.Get("ETag"); etag != "" {
+			req.Header.Set("If-None-Match", etag)
+		}
+		if lastModified := cached.Header.Get("Last-Modified"); lastModified != "" {
+			req.Header.Set("If-Modified-Since", lastModified)
+		}
+	}
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode == http.StatusNotModified && hasCached {
+		_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+		resp.Body.Close()
+		cached.Stored = time.Now()
+		writeCachedResponse(file, cached)
+		return cached.response(req), nil
+	}
+	if (resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNotFound) ||
+		resp.ContentLength > maxCachedBodySize {
+		return resp, nil
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxCachedBodySize+1))
+	if err != nil {
+		resp.Body.Close()
+		return nil, err
+	}
+	if len(body) > maxCachedBodySize {
+		// too big to cache so the rest of the body is streamed to the caller as normal
+		resp.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(body), resp.Body), resp.Body}
+		return resp, nil
+	}
+	resp.Body.Close()
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	writeCachedResponse(file, cachedResponse{
+		URL:    RedactURL(req.URL),
+		Status: resp.StatusCode,
+		Header: resp.Header.Clone(),
+		Body:   body,
+		Stored: time.Now(),
+	})
+	return resp, nil
+}
+
+// get how long the response for a request should be cached for. Zero means it shouldn't be.
+func responseTTL(req *http.Request) time.Duration {
+	conf := config.Get().ResponseCache
+	if !conf.Enabled ||
+		(req.Method != http.MethodGet && req.Method != http.MethodHead) ||
+		req.Header.Get("If-None-Match") != "" ||
+		req.Header.Get("If-Modified-Since") != "" ||
+		req.Header.Get("Range") != "" {
+		return 0
+	}
+	name, rest, ok := splitUpstream(req.URL)
+	if !ok {
+		return 0
+	}
+	endpoint, _, _ := strings.Cut(rest, "?")
+	if endpoint == "" {
+		endpoint = "/"
+	}
+	for _, rule := range conf.Rules {
+		if rule.Upstream != name {
+			continue
+		}
+		matched, err := path.Match(rule.Path, endpoint)
+		if err != nil {
+			lumber.Error(err, "invalid response cache path pattern", rule.Path)
+			continue
+		}
+		if matched {
+			return rule.TTL
+		}
+	}
+	return 0
+}
+
+func responseCacheFolder() string {
+	return filepath.Join(secrets.Get().CacheFolder, "upstream")
+}
+
+func cachedResponsePath(req *http.Request) string {
+	sum := sha256.Sum256([]byte(req.Method + " " + req.URL.String()))
+	return filepath.Join(responseCacheFolder(), hex.EncodeToString(sum[:])+".json")
+}
+
+func readCachedResponse(file string) (cachedResponse, error) {
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return cachedResponse{}, err
+	}
+	var cached cachedResponse
+	err = json.Unmarshal(b, &cached)
+	if err != nil {
+		return cachedResponse{}, err
+	}
+	return cached, nil
+}
+
+func writeCachedResponse(file string, cached cachedResponse) {
+	b, err := json.Marshal(cached)
+	if err != nil {
+		lumber.Error(err, "failed to encode cached response")
+		return
+	}
+	err = files.WriteAtomic(file, b, 0600)
+	if err != nil {
+		lumber.Error(err, "failed to write cached response to", file)
+	}
+}
+
+// remove cached responses that are too old to be useful, even for revalidation. Entries are never
+// removed otherwise so requests for URLs that aren't made anymore would pile up.
+func pruneCachedResponses() {
+	var longest time.Duration
+	for _, rule := range config.Get().ResponseCache.Rules {
+		longest = max(longest, rule.TTL)
+	}
+	entries, err := os.ReadDir(responseCacheFolder())
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			lumber.Error(err, "failed to read response cache folder")
+		}
+		return
+	}
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil || time.Since(info.ModTime()) < 2*longest {
+			continue
+		}
+		err = os.Remove(filepath.Join(responseCacheFolder(), entry.Name()))
+		if err != nil {
+			lumber.Error(err, "failed to remove old cached response", entry.Name())
+		}
+	}
+}
@@ -1,17 +1,19 @@
 package steam
 
 import (
+	"context"
 	"encoding/json"
-	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"net/url"
 	"sort"
+	"strings"
 	"time"
 
 	"github.com/gleich/lumber/v3"
 	"pkg.mattglei.ch/lcp-2/internal/apis"
+	"pkg.mattglei.ch/lcp-2/internal/config"
 	"pkg.mattglei.ch/lcp-2/internal/secrets"
 )
 
@@ -47,46 +49,64 @@ type achievement struct {
 	UnlockTime  *time.Time `json:"unlock_time"`
 }
 
-func fetchGameAchievements(appID int32) (*float32, *[]achievement, error) {
+// fetch the achievements for a game with their unlock times in the given location
+func fetchGameAchievements(
+	ctx context.Context,
+	appID int32,
+	location *time.Location,
+) (*float32, *[]achievement, error) {
 	params := url.Values{
-		"key":     {secrets.SECRETS.SteamKey},
-		"steamid": {secrets.SECRETS.SteamID},
+		"key":     {secrets.Get().SteamKey},
+		"steamid": {secrets.Get().SteamID},
 		"appid":   {fmt.Sprint(appID)},
 		"format":  {"json"},
 	}
-	resp, err := http.Get(
-		"https://api.steampowered.com/ISteamUserStats/GetPlayerAchievements/v0001?" + params.Encode(),
+	req, err := http.NewRequestWithContext(
+		ctx,
+		http.MethodGet,
+		apis.JoinURL(
+			config.Get().Upstreams.Steam,
+			"ISteamUserStats/GetPlayerAchievements/v0001?"+params.Encode(),
+		),
+		nil,
 	)
 	if err != nil {
-		lumber.Error(err, "sending request for player achievements from", appID, "failed")
+		lumber.Error(err, "creating request for player achievements from", appID, "failed")
 		return nil, nil, err
 	}
+	// sent without apis.SendRequest as steam responds to games without stats with an error body
+	resp, err := apis.Client.Do(req)
+	if err != nil {
+		upstreamErr := apis.NewSendError(req, err)
+		apis.LogUpstreamError(upstreamErr)
+		return nil, nil, upstreamErr
+	}
 	defer resp.Body.Close()
 
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		lumber.Error(err, "reading response body for player achievements from", appID, "failed")
-		return nil, nil, err
+		upstreamErr := apis.NewSendError(req, err)
+		apis.LogUpstreamError(upstreamErr)
+		return nil, nil, upstreamErr
 	}
-	if string(body) == `{"playerstats":{"error":"Requested app has no stats","success":false}}` {
+	if strings.TrimSpace(
+		string(body),
+	) == `{"playerstats":{"error":"Requested app has no stats","success":false}}` {
 		return nil, nil, err
 	}
 	if resp.StatusCode != http.StatusOK {
-		lumber.Warning(
-			"status code of",
-			resp.StatusCode,
-			"returned from API. Code of 200 expected from",
-			resp.Request.URL.String(),
-		)
-		return nil, nil, apis.WarningError
+		upstreamErr := apis.NewStatusError(req, resp.StatusCode, body)
+		apis.LogUpstreamError(upstreamErr)
+		return nil, nil, upstreamErr
 	}
 
 	var playerAchievements playerAchievementsResponse
 	err = json.Unmarshal(body, &playerAchievements)
 	if err != nil {
-		lumber.Error(err, "failed to parse json for player achievements for", appID)
+		upstreamErr := apis.NewRequestError(req, apis.Permanent, err)
+		apis.LogUpstreamError(upstreamErr)
 		lumber.Debug("body:", string(body))
-		return nil, nil, err
+		return nil, nil, upstreamErr
 	}
 
 	if playerAchievements.PlayerStats.Achievements == nil {
@@ -94,13 +114,17 @@ func fetchGameAchievements(appID int32) (*float32, *[]achievement, error) {
 	}
 
 	params = url.Values{
-		"key":    {secrets.SECRETS.SteamKey},
+		"key":    {secrets.Get().SteamKey},
 		"appid":  {fmt.Sprint(appID)},
 		"format": {"json"},
 	}
-	req, err := http.NewRequest(
+	req, err = http.NewRequestWithContext(
+		ctx,
 		http.MethodGet,
-		"https://api.steampowered.com/ISteamUserStats/GetSchemaForGame/v2?"+params.Encode(),
+		apis.JoinURL(
+			config.Get().Upstreams.Steam,
+			"ISteamUserStats/GetSchemaForGame/v2?"+params.Encode(),
+		),
 		nil,
 	)
 	if err != nil {
@@ -109,9 +133,6 @@ func fetchGameAchievements(appID int32) (*float32, *[]achievement, error) {
 	}
 	gameSchema, err := apis.SendRequest[schemaGameResponse](req)
 	if err != nil {
-		if !errors.Is(err, apis.WarningError) {
-			lumber.Error(err, "failed to get game schema for app id:", appID)
-		}
 		return nil, nil, err
 	}
 
@@ -121,7 +142,7 @@ func fetchGameAchievements(appID int32) (*float32, *[]achievement, error) {
 			if playerAchievement.ApiName == schemaAchievement.Name {
 				var unlockTime time.Time
 				if playerAchievement.UnlockTime != nil && *playerAchievement.UnlockTime != 0 {
-					unlockTime = time.Unix(*playerAchievement.UnlockTime, 0)
+					unlockTime = time.Unix(*playerAchievement.UnlockTime, 0).In(location)
 				}
 				achievements = append(achievements, achievement{
 					ApiName:     playerAchievement.ApiName,
@@ -156,8 +177,8 @@ func fetchGameAchievements(appID int32) (*float32, *[]achievement, error) {
 		return achievements[i].UnlockTime.After(*achievements[j].UnlockTime)
 	})
 
-	if len(achievements) > 5 {
-		achievements = achievements[:5]
+	if len(achievements) > config.Get().Steam.Achievements {
+		achievements = achievements[:config.Get().Steam.Achievements]
 	}
 
 	return &achievementPercentage, &achievements, nil
@@ -1,15 +1,17 @@
 package steam
 
 import (
-	"errors"
+	"context"
 	"fmt"
 	"net/http"
 	"net/url"
 	"sort"
+	"strings"
 	"time"
 
 	"github.com/gleich/lumber/v3"
 	"pkg.mattglei.ch/lcp-2/internal/apis"
+	"pkg.mattglei.ch/lcp-2/internal/config"
 	"pkg.mattglei.ch/lcp-2/internal/secrets"
 )
 
@@ -40,15 +42,18 @@ type game struct {
 	Achievements        *[]achievement `json:"achievements"`
 }
 
-func fetchRecentlyPlayedGames() ([]game, error) {
+// fetch the most recently played games with the times they were played and their achievements
+// were unlocked in the given location
+func fetchRecentlyPlayedGames(ctx context.Context, location *time.Location) ([]game, error) {
 	params := url.Values{
-		"key":             {secrets.SECRETS.SteamKey},
-		"steamid":         {secrets.SECRETS.SteamID},
+		"key":             {secrets.Get().SteamKey},
+		"steamid":         {secrets.Get().SteamID},
 		"include_appinfo": {"true"},
 		"format":          {"json"},
 	}
-	req, err := http.NewRequest(http.MethodGet,
-		"https://api.steampowered.com/IPlayerService/GetOwnedGames/v1?"+params.Encode(), nil,
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet,
+		apis.JoinURL(config.Get().Upstreams.Steam, "IPlayerService/GetOwnedGames/v1?"+params.Encode()),
+		nil,
 	)
 	if err != nil {
 		lumber.Error(err, "failed to create request for steam API owned games")
@@ -56,9 +61,6 @@ func fetchRecentlyPlayedGames() ([]game, error) {
 	}
 	ownedGames, err := apis.SendRequest[ownedGamesResponse](req)
 	if err != nil {
-		if !errors.Is(err, apis.WarningError) {
-			lumber.Error(err, "sending request for owned games failed")
-		}
 		return nil, err
 	}
 
@@ -66,31 +68,39 @@ func fetchRecentlyPlayedGames() ([]game, error) {
 		return ownedGames.Response.Games[i].RTimeLastPlayed > ownedGames.Response.Games[j].RTimeLastPlayed
 	})
 
+	assetsURL := strings.TrimRight(config.Get().Upstreams.SteamAssets, "/")
 	var games []game
 	i := 0
-	for len(games) < 10 {
-		if i > len(games) {
+	for len(games) < config.Get().Steam.Games {
+		if i >= len(ownedGames.Response.Games) {
 			break
 		}
 		g := ownedGames.Response.Games[i]
 		i++
 		libraryURL := fmt.Sprintf(
-			"https://shared.akamai.steamstatic.com/store_item_assets/steam/apps/%d/library_600x900.jpg",
+			"%s/store_item_assets/steam/apps/%d/library_600x900.jpg",
+			assetsURL,
 			g.AppID,
 		)
-		libraryImageResponse, err := http.Get(libraryURL)
+		req, err := http.NewRequestWithContext(ctx, http.MethodHead, libraryURL, nil)
 		if err != nil {
-			lumber.Error(err, "getting library image for", g.Name, "failed")
+			lumber.Error(err, "creating request for library image for", g.Name, "failed")
 			return nil, err
 		}
-		defer libraryImageResponse.Body.Close()
+		libraryImageResponse, err := apis.Client.Do(req)
+		if err != nil {
+			upstreamErr := apis.NewSendError(req, err)
+			apis.LogUpstreamError(upstreamErr)
+			return nil, upstreamErr
+		}
+		libraryImageResponse.Body.Close()
 
 		var libraryURLPtr *string
 		if libraryImageResponse.StatusCode == http.StatusOK {
 			libraryURLPtr = &libraryURL
 		}
 
-		achievementPercentage, achievements, err := fetchGameAchievements(g.AppID)
+		achievementPercentage, achievements, err := fetchGameAchievements(ctx, g.AppID, location)
 		if err != nil {
 			return nil, err
 		}
@@ -103,20 +113,23 @@ func fetchRecentlyPlayedGames() ([]game, error) {
 				g.AppID,
 				g.ImgIconURL,
 			),
-			RTimeLastPlayed: time.Unix(g.RTimeLastPlayed, 0),
+			RTimeLastPlayed: time.Unix(g.RTimeLastPlayed, 0).In(location),
 			PlaytimeForever: g.PlaytimeForever,
 			URL:             fmt.Sprintf("https://store.steampowered.com/app/%d/", g.AppID),
 			HeaderURL: fmt.Sprintf(
-				"https://shared.akamai.steamstatic.com/store_item_assets/steam/apps/%d/header.jpg",
+				"%s/store_item_assets/steam/apps/%d/header.jpg",
+				assetsURL,
 				g.AppID,
 			),
 			LibraryURL: libraryURLPtr,
 			LibraryHeroURL: fmt.Sprintf(
-				"https://shared.akamai.steamstatic.com/store_item_assets/steam/apps/%d/library_hero.jpg",
+				"%s/store_item_assets/steam/apps/%d/library_hero.jpg",
+				assetsURL,
 				g.AppID,
 			),
 			LibraryHeroLogoURL: fmt.Sprintf(
-				"https://shared.akamai.steamstatic.com/store_item_assets/steam/apps/%d/logo.png",
+				"%s/store_item_assets/steam/apps/%d/logo.png",
+				assetsURL,
 				g.AppID,
 			),
 			AchievementProgress: achievementPercentage,
@@ -0,0 +1,18 @@
+package steam
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"pkg.mattglei.ch/lcp-2/internal/apis/replay"
+)
+
+func TestFetchRecentlyPlayedGames(t *testing.T) {
+	replay.Use(t, "fetch_recently_played_games")
+	games, err := fetchRecentlyPlayedGames(context.Background(), time.UTC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	replay.Golden(t, "fetch_recently_played_games", games)
+}
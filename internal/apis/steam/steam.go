@@ -1,21 +1,32 @@
 package steam
 
 import (
+	"context"
 	"net/http"
 	"time"
 
 	"github.com/gleich/lumber/v3"
+	"pkg.mattglei.ch/lcp-2/internal/apis"
 	"pkg.mattglei.ch/lcp-2/internal/cache"
+	"pkg.mattglei.ch/lcp-2/internal/config"
 )
 
 func Setup(mux *http.ServeMux) {
-	games, err := fetchRecentlyPlayedGames()
+	games, err := fetchRecentlyPlayedGames(context.Background(), time.Local)
 	if err != nil {
-		lumber.Error(err, "initial fetch of games failed")
+		apis.LogFailure(err, "initial fetch of games failed")
 	}
 
 	steamCache := cache.New("steam", games, err == nil)
+	if err != nil {
+		steamCache.RecordError(err)
+	}
 	mux.HandleFunc("GET /steam", steamCache.ServeHTTP)
-	go steamCache.UpdatePeriodically(fetchRecentlyPlayedGames, 5*time.Minute)
+	go steamCache.UpdatePeriodically(
+		func(ctx context.Context) ([]game, error) {
+			return fetchRecentlyPlayedGames(ctx, time.Local)
+		},
+		func() time.Duration { return config.Get().Steam.Interval },
+	)
 	lumber.Done("setup steam cache")
 }
@@ -1,13 +1,16 @@
 package strava
 
 import (
+	"context"
 	"fmt"
 	"image/png"
-	"net/url"
+	"strings"
 	"time"
 
 	"github.com/gleich/lumber/v3"
 	"github.com/minio/minio-go/v7"
+	"pkg.mattglei.ch/lcp-2/internal/apis"
+	"pkg.mattglei.ch/lcp-2/internal/config"
 	"pkg.mattglei.ch/lcp-2/internal/images"
 )
 
@@ -38,14 +41,8 @@ type stravaActivity struct {
 	HasHeartrate       bool    `json:"has_heartrate"`
 }
 
-type activityStream struct {
-	Data         []int  `json:"data"`
-	SeriesType   string `json:"series_type"`
-	OriginalSize int    `json:"original_size"`
-	Resolution   string `json:"resolution"`
-}
-
 type detailedStravaActivity struct {
+	stravaActivity
 	Calories float32 `json:"calories"`
 }
 
@@ -56,97 +53,162 @@ type activity struct {
 	Timezone           string    `json:"timezone"`
 	MapBlurImage       *string   `json:"map_blur_image"`
 	MapImageURL        *string   `json:"map_image_url"`
+	MapSVGURL          *string   `json:"map_svg_url,omitempty"`
 	HasMap             bool      `json:"has_map"`
+	SummaryPolyline    string    `json:"summary_polyline,omitempty"`
 	TotalElevationGain float32   `json:"total_elevation_gain"`
 	MovingTime         uint32    `json:"moving_time"`
 	Distance           float32   `json:"distance"`
 	ID                 uint64    `json:"id"`
 	AverageHeartrate   float32   `json:"average_heartrate"`
 	HeartrateData      []int     `json:"heartrate_data"`
-	Calories           float32   `json:"calories"`
+	// time in each of the heart rate zones from the config
+	HeartrateZones []heartrateZone `json:"heartrate_zones,omitempty"`
+	Calories       float32         `json:"calories"`
 }
 
-func fetchActivities(minioClient minio.Client, tokens tokens) ([]activity, error) {
+// fetch the latest activities. Previous activities are used to fill in the heart rate and calorie
+// data when the strava rate limit budget is too low to fetch it again.
+func fetchActivities(
+	ctx context.Context,
+	minioClient minio.Client,
+	tokens *tokens,
+	previous []activity,
+) ([]activity, error) {
+	if !tokens.authorized() {
+		return nil, errDeauthorized
+	}
 	stravaActivities, err := sendStravaAPIRequest[[]stravaActivity](
+		ctx,
 		"api/v3/athlete/activities",
 		tokens,
 	)
 	if err != nil {
-		lumber.Error(err, "failed to send request to Strava API to get activities")
 		return nil, err
 	}
 
+	lowBudget := apis.BudgetLow("strava")
+	if lowBudget {
+		lumber.Warning("strava rate limit budget is low; skipping activity enrichment")
+	}
+
 	var activities []activity
 	for _, stravaActivity := range stravaActivities {
-		if len(activities) >= 5 {
+		if len(activities) >= config.Get().Strava.Activities {
 			break
 		}
-		if stravaActivity.Private || !stravaActivity.HasHeartrate || stravaActivity.Commute {
+		if !stravaActivity.shown() {
 			continue
 		}
 
-		details, err := fetchActivityDetails(stravaActivity.ID, tokens)
-		if err != nil {
-			lumber.Error(err, "failed to fetch activity details")
-			continue
+		var (
+			details   detailedStravaActivity
+			heartrate []int
+			zones     []heartrateZone
+		)
+		if lowBudget {
+			for _, p := range previous {
+				if p.ID == stravaActivity.ID {
+					details.Calories = p.Calories
+					heartrate = p.HeartrateData
+					zones = p.HeartrateZones
+					break
+				}
+			}
+		} else {
+			details, err = fetchActivityDetails(ctx, stravaActivity.ID, tokens)
+			if err != nil {
+				continue
+			}
+			s, err := loadStreams(ctx, stravaActivity.ID, tokens)
+			if err == nil {
+				heartrate = heartrateData(s)
+			}
+			zones = heartrateZones(activityHistogram(ctx, stravaActivity.ID, s, err, tokens))
 		}
 
-		a := activity{
-			Name:               stravaActivity.Name,
-			SportType:          stravaActivity.SportType,
-			StartDate:          stravaActivity.StartDate,
-			Timezone:           stravaActivity.Timezone,
-			TotalElevationGain: stravaActivity.TotalElevationGain,
-			MovingTime:         stravaActivity.MovingTime,
-			Distance:           stravaActivity.Distance,
-			ID:                 stravaActivity.ID,
-			AverageHeartrate:   stravaActivity.AverageHeartrate,
-			HasMap:             stravaActivity.Map.SummaryPolyline != "",
-			HeartrateData:      fetchHeartrate(stravaActivity.ID, tokens),
-			Calories:           details.Calories,
-		}
-		if a.HasMap {
-			mapData := fetchMap(stravaActivity.Map.SummaryPolyline)
-			uploadMap(minioClient, stravaActivity.ID, mapData)
-			mapBlurURI := images.BlurDataURI(images.BlurImage(mapData, png.Decode))
-			a.MapBlurImage = &mapBlurURI
-			imgurl := fmt.Sprintf(
-				"https://minio-api.dev.mattglei.ch/mapbox-maps/%d.png",
-				a.ID,
-			)
-			a.MapImageURL = &imgurl
-		}
+		a := newActivity(ctx, minioClient, stravaActivity, details.Calories, heartrate)
+		a.HeartrateZones = zones
 		activities = append(activities, a)
 	}
-	removeOldMaps(minioClient, activities)
+	removeOldMaps(ctx, minioClient, activities)
+	removeOldStreams(activities)
 
 	return activities, nil
 }
 
-func fetchHeartrate(id uint64, tokens tokens) []int {
-	params := url.Values{
-		"key_by_type": {"true"},
-		"keys":        {"heartrate"},
-		"resolution":  {"low"},
+// if an activity should be included in the cache
+func (a stravaActivity) shown() bool {
+	return !a.Private && a.HasHeartrate && !a.Commute
+}
+
+// create an activity from strava's data, rendering and uploading its map if it has one
+func newActivity(
+	ctx context.Context,
+	minioClient minio.Client,
+	stravaActivity stravaActivity,
+	calories float32,
+	heartrate []int,
+) activity {
+	a := activity{
+		Name:               stravaActivity.Name,
+		SportType:          stravaActivity.SportType,
+		StartDate:          stravaActivity.StartDate,
+		Timezone:           stravaActivity.Timezone,
+		TotalElevationGain: stravaActivity.TotalElevationGain,
+		MovingTime:         stravaActivity.MovingTime,
+		Distance:           stravaActivity.Distance,
+		ID:                 stravaActivity.ID,
+		AverageHeartrate:   stravaActivity.AverageHeartrate,
+		HasMap:             stravaActivity.Map.SummaryPolyline != "",
+		SummaryPolyline:    stravaActivity.Map.SummaryPolyline,
+		HeartrateData:      heartrate,
+		Calories:           calories,
 	}
-	stream, err := sendStravaAPIRequest[struct{ Heartrate activityStream }](
-		fmt.Sprintf("api/v3/activities/%d/streams?%s", id, params.Encode()),
-		tokens,
-	)
+	if a.HasMap {
+		addMap(ctx, minioClient, &a, a.SummaryPolyline)
+	}
+	return a
+}
+
+// render the map for an activity and upload it. The map fields are left empty if the map can't be
+// rendered so the activity is still shown.
+func addMap(ctx context.Context, minioClient minio.Client, a *activity, polyline string) {
+	rendered, err := renderMap(ctx, polyline)
 	if err != nil {
-		lumber.Error(err, "failed to send request for HR data from activity with ID of", id)
+		if apis.IsRetriable(err) {
+			lumber.Warning("failed to render map for activity", a.ID, err)
+		} else {
+			lumber.Error(err, "failed to render map for activity", a.ID)
+		}
+		return
 	}
+	mapsURL := strings.TrimRight(config.Get().Strava.MapsURL, "/")
 
-	return stream.Heartrate.Data
+	uploadMap(ctx, minioClient, fmt.Sprintf("%d.png", a.ID), "image/png", rendered.png)
+	mapBlurURI := images.BlurDataURI(images.BlurImage(rendered.png, png.Decode))
+	a.MapBlurImage = &mapBlurURI
+	imgurl := fmt.Sprintf("%s/%d.png", mapsURL, a.ID)
+	a.MapImageURL = &imgurl
+
+	if rendered.svg != nil {
+		uploadMap(ctx, minioClient, fmt.Sprintf("%d.svg", a.ID), "image/svg+xml", rendered.svg)
+		svgurl := fmt.Sprintf("%s/%d.svg", mapsURL, a.ID)
+		a.MapSVGURL = &svgurl
+	}
 }
 
-func fetchActivityDetails(id uint64, tokens tokens) (detailedStravaActivity, error) {
+func fetchActivityDetails(
+	ctx context.Context,
+	id uint64,
+	tokens *tokens,
+) (detailedStravaActivity, error) {
 	details, err := sendStravaAPIRequest[detailedStravaActivity](
+		ctx,
 		fmt.Sprintf("api/v3/activities/%d", id),
 		tokens,
 	)
 	if err != nil {
-		lumber.Error(err, "failed to request detailed activity data for", id)
 		return detailedStravaActivity{}, err
 	}
 
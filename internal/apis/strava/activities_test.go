@@ -0,0 +1,24 @@
+package strava
+
+import (
+	"context"
+	"testing"
+
+	"pkg.mattglei.ch/lcp-2/internal/apis/replay"
+	"pkg.mattglei.ch/lcp-2/internal/secrets"
+)
+
+func TestFetchActivities(t *testing.T) {
+	transport := replay.Use(t, "fetch_activities")
+	minioClient, err := newMinioClient(transport)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stravaTokens := &tokens{set: tokenSet{Access: secrets.Get().StravaAccessToken}}
+
+	activities, err := fetchActivities(context.Background(), *minioClient, stravaTokens, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	replay.Golden(t, "fetch_activities", activities)
+}
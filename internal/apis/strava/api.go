@@ -1,34 +1,31 @@
 package strava
 
 import (
-	"errors"
-	"fmt"
+	"context"
 	"net/http"
-	"strings"
 
 	"github.com/gleich/lumber/v3"
 	"pkg.mattglei.ch/lcp-2/internal/apis"
+	"pkg.mattglei.ch/lcp-2/internal/config"
 )
 
-func sendStravaAPIRequest[T any](path string, tokens tokens) (T, error) {
+func sendStravaAPIRequest[T any](ctx context.Context, path string, tokens *tokens) (T, error) {
 	var zeroValue T
 
-	req, err := http.NewRequest(
+	req, err := http.NewRequestWithContext(
+		ctx,
 		http.MethodGet,
-		fmt.Sprintf("https://www.strava.com/%s", strings.TrimLeft(path, "/")),
+		apis.JoinURL(config.Get().Upstreams.Strava, path),
 		nil,
 	)
 	if err != nil {
 		lumber.Error(err, "failed to create request")
 		return zeroValue, err
 	}
-	req.Header.Set("Authorization", "Bearer "+tokens.Access)
+	req.Header.Set("Authorization", "Bearer "+tokens.access())
 
 	resp, err := apis.SendRequest[T](req)
 	if err != nil {
-		if !errors.Is(err, apis.WarningError) {
-			lumber.Error(err, "failed to make strava API request")
-		}
 		return zeroValue, err
 	}
 	return resp, nil
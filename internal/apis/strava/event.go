@@ -1,14 +1,22 @@
 package strava
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"io"
 	"net/http"
+	"slices"
+	"time"
 
 	"github.com/gleich/lumber/v3"
 	"github.com/minio/minio-go/v7"
+	"pkg.mattglei.ch/lcp-2/internal/apis"
 	"pkg.mattglei.ch/lcp-2/internal/cache"
+	"pkg.mattglei.ch/lcp-2/internal/config"
+	"pkg.mattglei.ch/lcp-2/internal/metrics"
 	"pkg.mattglei.ch/lcp-2/internal/secrets"
+	"pkg.mattglei.ch/lcp-2/internal/tracing"
 )
 
 type event struct {
@@ -21,11 +29,24 @@ type event struct {
 	Updates        map[string]string `json:"updates"`
 }
 
-func eventRoute(
-	stravaCache *cache.Cache[[]activity],
-	minioClient minio.Client,
-	tokens tokens,
-) http.HandlerFunc {
+// error recorded on the strava cache once the athlete revokes lcp's access
+var errDeauthorized = &apis.UpstreamError{
+	Upstream: "strava",
+	Kind:     apis.AuthExpired,
+	Err:      errors.New("athlete revoked access; lcp needs to be authorized with strava again"),
+}
+
+// event queued after lcp is authorized through the oauth flow so every activity is fetched again.
+// Strava only sends athlete events when access is revoked so it can't be mistaken for one of them.
+var authorizedEvent = event{
+	AspectType: "update",
+	ObjectType: "athlete",
+	Updates:    map[string]string{"authorized": "true"},
+}
+
+// acknowledge webhook events right away and queue them to be handled in the background as strava
+// retries events that aren't acknowledged within two seconds
+func eventRoute(queue *eventQueue) http.HandlerFunc {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		body, err := io.ReadAll(r.Body)
 		if err != nil {
@@ -42,24 +63,205 @@ func eventRoute(
 			return
 		}
 
-		if eventData.SubscriptionID != secrets.SECRETS.StravaSubscriptionID {
+		if eventData.SubscriptionID != secrets.Get().StravaSubscriptionID {
 			w.WriteHeader(http.StatusUnauthorized)
 			return
 		}
 
-		tokens.refreshIfNeeded()
-		activities, err := fetchActivities(minioClient, tokens)
+		metrics.ObserveStravaEvent(eventData.ObjectType, eventData.AspectType)
+		queue.receive(eventData)
+	})
+}
+
+// create the function that the event queue's worker handles events with
+func eventHandler(
+	stravaCache *cache.Cache[[]activity],
+	statsCache *cache.Cache[stats],
+	history *history,
+	minioClient minio.Client,
+	tokens *tokens,
+) func(ctx context.Context, e event) error {
+	return func(ctx context.Context, e event) error {
+		start := time.Now()
+		ctx, span := tracing.StartRoot(ctx, "strava refresh")
+		err := handleEvent(ctx, e, stravaCache, statsCache, history, minioClient, tokens)
+		tracing.End(span, err)
+		metrics.ObserveRefresh("strava", time.Since(start), err)
 		if err != nil {
-			lumber.ErrorMsg("failed to update strava cache")
-			return
+			stravaCache.RecordError(err)
+		}
+		return err
+	}
+}
+
+// apply a single event to the cached activities and the history behind the stats, only fetching the
+// activity that it's about. Events are handled one at a time by the event queue's worker.
+func handleEvent(
+	ctx context.Context,
+	e event,
+	stravaCache *cache.Cache[[]activity],
+	statsCache *cache.Cache[stats],
+	history *history,
+	minioClient minio.Client,
+	tokens *tokens,
+) error {
+	switch e.ObjectType {
+	case "athlete":
+		switch e.Updates["authorized"] {
+		case "false":
+			err := tokens.deauthorize()
+			if err != nil {
+				lumber.Error(err, "failed to persist strava deauthorization")
+			}
+			stravaCache.RecordError(errDeauthorized)
+			lumber.Warning("strava athlete revoked access; ignoring events until lcp is authorized")
+		case "true":
+			err := refreshActivities(ctx, stravaCache, minioClient, tokens)
+			if err != nil {
+				return err
+			}
+			s, err := history.sync(ctx, tokens)
+			if err != nil {
+				return err
+			}
+			statsCache.Update(s)
+		}
+		return nil
+	case "activity":
+	default:
+		return nil
+	}
+	if !tokens.authorized() {
+		lumber.Warning("ignoring strava event for", e.ObjectID, "as lcp isn't authorized")
+		return nil
+	}
+	tokens.refreshIfNeeded(ctx)
+
+	id := uint64(e.ObjectID)
+	// details are only needed when an activity might have to be added or when its type changed, as
+	// the type in the event is the activity's type from before strava had sport types. Heart rate
+	// doesn't change with the type.
+	var (
+		details   *detailedStravaActivity
+		histogram heartrateHistogram
+	)
+	added := e.AspectType == "create" || e.Updates["private"] == "false"
+	if added || e.Updates["type"] != "" {
+		fetched, err := fetchActivityDetails(ctx, id, tokens)
+		if err != nil {
+			return err
 		}
-		stravaCache.Update(activities)
+		details = &fetched
+		if added && fetched.HasHeartrate && !fetched.Private {
+			s, err := loadStreams(ctx, id, tokens)
+			histogram = activityHistogram(ctx, id, s, err, tokens)
+		}
+	}
+	if history.apply(e, details, histogram) {
+		statsCache.Update(history.stats())
+	}
+
+	stravaCache.DataMutex.RLock()
+	activities := slices.Clone(stravaCache.Data)
+	stravaCache.DataMutex.RUnlock()
+	index := slices.IndexFunc(activities, func(a activity) bool { return a.ID == id })
+
+	switch e.AspectType {
+	case "create":
+		activities = addActivity(ctx, minioClient, tokens, activities, *details, histogram)
+	case "update":
+		private, privacyChanged := e.Updates["private"]
+		switch {
+		case index != -1 && private == "true":
+			// fetched again so the next most recent activity takes its place
+			return refreshActivities(ctx, stravaCache, minioClient, tokens)
+		case index != -1:
+			if title, ok := e.Updates["title"]; ok {
+				activities[index].Name = title
+			}
+			if details != nil {
+				activities[index].Name = details.Name
+				activities[index].SportType = details.SportType
+			}
+		case privacyChanged && private == "false":
+			// activity that was private might belong in the cache now that it's public
+			activities = addActivity(ctx, minioClient, tokens, activities, *details, histogram)
+		default:
+			return nil
+		}
+	case "delete":
+		if index == -1 {
+			return nil
+		}
+		return refreshActivities(ctx, stravaCache, minioClient, tokens)
+	default:
+		return nil
+	}
+
+	stravaCache.Update(activities)
+	removeOldMaps(ctx, minioClient, activities)
+	removeOldStreams(activities)
+	return nil
+}
+
+// fetch every cached activity again
+func refreshActivities(
+	ctx context.Context,
+	stravaCache *cache.Cache[[]activity],
+	minioClient minio.Client,
+	tokens *tokens,
+) error {
+	tokens.refreshIfNeeded(ctx)
+	stravaCache.DataMutex.RLock()
+	previous := slices.Clone(stravaCache.Data)
+	stravaCache.DataMutex.RUnlock()
+	activities, err := fetchActivities(ctx, minioClient, tokens, previous)
+	if err != nil {
+		return err
+	}
+	stravaCache.Update(activities)
+	return nil
+}
+
+// add an activity to the activities if it's shown and recent enough, keeping them sorted from
+// newest to oldest
+func addActivity(
+	ctx context.Context,
+	minioClient minio.Client,
+	tokens *tokens,
+	activities []activity,
+	details detailedStravaActivity,
+	histogram heartrateHistogram,
+) []activity {
+	id := details.ID
+	activities = slices.DeleteFunc(activities, func(a activity) bool { return a.ID == id })
+	if !details.shown() {
+		return activities
+	}
+	index := slices.IndexFunc(activities, func(a activity) bool {
+		return a.StartDate.Before(details.StartDate)
 	})
+	if index == -1 {
+		index = len(activities)
+	}
+	if index >= config.Get().Strava.Activities {
+		return activities
+	}
+
+	var heartrate []int
+	s, err := loadStreams(ctx, id, tokens)
+	if err == nil {
+		heartrate = heartrateData(s)
+	}
+	a := newActivity(ctx, minioClient, details.stravaActivity, details.Calories, heartrate)
+	a.HeartrateZones = heartrateZones(histogram)
+	activities = slices.Insert(activities, index, a)
+	return activities[:min(len(activities), config.Get().Strava.Activities)]
 }
 
 func challengeRoute(w http.ResponseWriter, r *http.Request) {
 	verifyToken := r.URL.Query().Get("hub.verify_token")
-	if verifyToken != secrets.SECRETS.StravaVerifyToken {
+	if verifyToken != secrets.Get().StravaVerifyToken {
 		w.WriteHeader(http.StatusUnauthorized)
 		return
 	}
@@ -0,0 +1,118 @@
+package strava
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"strconv"
+
+	"github.com/gleich/lumber/v3"
+	"pkg.mattglei.ch/lcp-2/internal/auth"
+	"pkg.mattglei.ch/lcp-2/internal/cache"
+)
+
+// GeoJSON positions are longitude, latitude pairs
+type position [2]float64
+
+type geoJSONFeature struct {
+	Type       string            `json:"type"`
+	BBox       [4]float64        `json:"bbox"`
+	Geometry   geoJSONLineString `json:"geometry"`
+	Properties geoJSONProperties `json:"properties"`
+}
+
+type geoJSONLineString struct {
+	Type        string     `json:"type"`
+	Coordinates []position `json:"coordinates"`
+}
+
+type geoJSONProperties struct {
+	ID        uint64   `json:"id"`
+	Name      string   `json:"name"`
+	SportType string   `json:"sport_type"`
+	Start     position `json:"start"`
+	End       position `json:"end"`
+	// simplification tolerance in meters that was used for the coordinates
+	Tolerance float64 `json:"tolerance"`
+}
+
+// serve the route of an activity as a GeoJSON feature. The optional tolerance query parameter is
+// the max distance in meters that a simplified route can stray from the original.
+func geoJSONRoute(stravaCache *cache.Cache[[]activity]) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !auth.IsAuthorized(w, r) {
+			return
+		}
+		id, err := strconv.ParseUint(r.PathValue("id"), 10, 64)
+		if err != nil {
+			http.Error(w, "invalid activity ID", http.StatusBadRequest)
+			return
+		}
+		var tolerance float64
+		if value := r.URL.Query().Get("tolerance"); value != "" {
+			tolerance, err = strconv.ParseFloat(value, 64)
+			if err != nil || tolerance < 0 || math.IsNaN(tolerance) || math.IsInf(tolerance, 0) {
+				http.Error(w, "tolerance must be a positive number of meters", http.StatusBadRequest)
+				return
+			}
+		}
+
+		var (
+			found bool
+			a     activity
+		)
+		stravaCache.DataMutex.RLock()
+		for _, cached := range stravaCache.Data {
+			if cached.ID == id {
+				a = cached
+				found = true
+				break
+			}
+		}
+		stravaCache.DataMutex.RUnlock()
+		if !found || a.SummaryPolyline == "" {
+			http.Error(w, "no route for activity", http.StatusNotFound)
+			return
+		}
+
+		points, err := decodePolyline(a.SummaryPolyline)
+		if err != nil {
+			lumber.Error(err, "failed to decode polyline for activity", id)
+			http.Error(w, "failed to decode route", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/geo+json")
+		feature := newGeoJSONFeature(a, simplifyRoute(points, tolerance), tolerance)
+		err = json.NewEncoder(w).Encode(feature)
+		if err != nil {
+			lumber.Error(err, "failed to write geojson for activity", id)
+		}
+	})
+}
+
+func newGeoJSONFeature(a activity, points [][2]float64, tolerance float64) geoJSONFeature {
+	coordinates := make([]position, len(points))
+	bbox := [4]float64{math.Inf(1), math.Inf(1), math.Inf(-1), math.Inf(-1)}
+	for i, p := range points {
+		coordinates[i] = position{p[1], p[0]}
+		bbox[0], bbox[1] = min(bbox[0], p[1]), min(bbox[1], p[0])
+		bbox[2], bbox[3] = max(bbox[2], p[1]), max(bbox[3], p[0])
+	}
+	return geoJSONFeature{
+		Type: "Feature",
+		BBox: bbox,
+		Geometry: geoJSONLineString{
+			Type:        "LineString",
+			Coordinates: coordinates,
+		},
+		Properties: geoJSONProperties{
+			ID:        a.ID,
+			Name:      a.Name,
+			SportType: a.SportType,
+			Start:     coordinates[0],
+			End:       coordinates[len(coordinates)-1],
+			Tolerance: tolerance,
+		},
+	}
+}
@@ -0,0 +1,335 @@
+package strava
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/gleich/lumber/v3"
+	"pkg.mattglei.ch/lcp-2/internal/apis"
+	"pkg.mattglei.ch/lcp-2/internal/files"
+	"pkg.mattglei.ch/lcp-2/internal/secrets"
+)
+
+// most activities that strava returns in a single page
+const historyPageSize = 200
+
+// most activities that calories and heart rate are fetched for in a single sync. The list of
+// activities doesn't include either so every activity needs its own requests and they're filled in
+// over a few syncs to stay within the rate limit.
+const backfillLimit = 100
+
+// summary of an activity in the athlete's history that stats and training load are computed from
+type historyActivity struct {
+	ID                 uint64    `json:"id"`
+	SportType          string    `json:"sport_type"`
+	StartDate          time.Time `json:"start_date"`
+	Timezone           string    `json:"timezone"`
+	Distance           float32   `json:"distance"`
+	MovingTime         uint32    `json:"moving_time"`
+	TotalElevationGain float32   `json:"total_elevation_gain"`
+	AverageHeartrate   float32   `json:"average_heartrate,omitempty"`
+	SufferScore        float32   `json:"suffer_score,omitempty"`
+	// nil until the activity's details have been fetched. Zero if they couldn't be.
+	Calories *float32 `json:"calories,omitempty"`
+	// nil until the activity's heart rate has been fetched. Empty if it couldn't be.
+	HeartrateHistogram heartrateHistogram `json:"heartrate_histogram"`
+}
+
+func newHistoryActivity(a stravaActivity) historyActivity {
+	return historyActivity{
+		ID:                 a.ID,
+		SportType:          a.SportType,
+		StartDate:          a.StartDate,
+		Timezone:           a.Timezone,
+		Distance:           a.Distance,
+		MovingTime:         a.MovingTime,
+		TotalElevationGain: a.TotalElevationGain,
+		AverageHeartrate:   a.AverageHeartrate,
+		SufferScore:        a.SufferScore,
+	}
+}
+
+// every activity that isn't private from the athlete's whole history, sorted from newest to
+// oldest. It's persisted in the cache folder so the history doesn't need to be fetched again after
+// a restart.
+type history struct {
+	mutex      sync.Mutex
+	activities []historyActivity
+
+	// count of the events that changed the history, which syncs start from so they can tell which
+	// activities changed while they were fetching it
+	applied uint64
+	// number of syncs that are fetching the history and, for every activity that changed since the
+	// first of them started, the count of applied events when it last changed
+	syncs   int
+	changed map[uint64]uint64
+}
+
+func historyFilePath() string {
+	return filepath.Join(secrets.Get().CacheFolder, "strava-history.json")
+}
+
+// load the history that was persisted by the last sync. ok is false if it has never been synced.
+func loadHistory() (h *history, ok bool) {
+	h = &history{}
+	b, err := os.ReadFile(historyFilePath())
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			lumber.Error(err, "failed to load strava history")
+		}
+		return h, false
+	}
+	err = json.Unmarshal(b, &h.activities)
+	if err != nil {
+		lumber.Error(err, "failed to parse strava history")
+		return h, false
+	}
+	return h, true
+}
+
+// write the history to disk. Must be called with the mutex held.
+func (h *history) persist() {
+	b, err := json.Marshal(h.activities)
+	if err != nil {
+		lumber.Error(err, "failed to json marshal strava history")
+		return
+	}
+	err = files.WriteAtomic(historyFilePath(), b, 0600)
+	if err != nil {
+		lumber.Error(err, "failed to write strava history")
+	}
+}
+
+// fetch the athlete's whole history again, keeping the calories and heart rate that were already
+// fetched, and fill them in for activities that don't have them yet. Returns the stats for the new
+// history.
+func (h *history) sync(ctx context.Context, tokens *tokens) (stats, error) {
+	if !tokens.authorized() {
+		return stats{}, errDeauthorized
+	}
+	tokens.refreshIfNeeded(ctx)
+
+	// pages are fetched without holding the mutex so events can still be applied and the history
+	// read in the meantime
+	h.mutex.Lock()
+	start := h.applied
+	h.syncs++
+	h.mutex.Unlock()
+	fetched, err := fetchHistory(ctx, tokens)
+
+	h.mutex.Lock()
+	h.syncs--
+	if err != nil {
+		h.forgetChanges()
+		h.mutex.Unlock()
+		return stats{}, err
+	}
+	previous := map[uint64]historyActivity{}
+	for _, a := range h.activities {
+		previous[a.ID] = a
+	}
+	// activities that events changed while the pages were being fetched are kept as they are now
+	// as the pages might be from before the events
+	var activities []historyActivity
+	for _, a := range fetched {
+		if h.changed[a.ID] > start {
+			continue
+		}
+		a.Calories = previous[a.ID].Calories
+		a.HeartrateHistogram = previous[a.ID].HeartrateHistogram
+		activities = append(activities, a)
+	}
+	for id, applied := range h.changed {
+		a, ok := previous[id]
+		if ok && applied > start {
+			activities = append(activities, a)
+		}
+	}
+	sortHistory(activities)
+	var missing []historyActivity
+	for _, a := range activities {
+		if a.needsBackfill() {
+			missing = append(missing, a)
+		}
+	}
+	h.activities = activities
+	h.forgetChanges()
+	h.persist()
+	h.mutex.Unlock()
+
+	// requests are sent without holding the mutex as there can be a lot of them
+	for _, a := range missing[:min(len(missing), backfillLimit)] {
+		if apis.BudgetLow("strava") {
+			lumber.Warning("strava rate limit budget is low; filling in history later")
+			break
+		}
+		var err error
+		if a.Calories == nil {
+			var details detailedStravaActivity
+			details, err = fetchActivityDetails(ctx, a.ID, tokens)
+			switch {
+			case err == nil:
+				a.Calories = &details.Calories
+			case apis.KindOf(err) == apis.Permanent:
+				// fetching them again won't help so they're marked as fetched with none available to
+				// keep the activity from filling up the backfill of every sync
+				a.Calories = new(float32)
+			}
+		}
+		if err != nil && apis.KindOf(err) != apis.Permanent {
+			break
+		}
+		if a.HeartrateHistogram == nil && a.AverageHeartrate > 0 {
+			a.HeartrateHistogram, err = fetchHeartrateHistogram(ctx, a.ID, tokens)
+			if err != nil && apis.KindOf(err) != apis.Permanent {
+				break
+			}
+			if err != nil {
+				// fetching it again won't help either so it's marked as not available
+				a.HeartrateHistogram = heartrateHistogram{}
+			}
+		}
+		h.mutex.Lock()
+		index := slices.IndexFunc(h.activities, func(b historyActivity) bool { return b.ID == a.ID })
+		if index != -1 {
+			h.activities[index].Calories = a.Calories
+			h.activities[index].HeartrateHistogram = a.HeartrateHistogram
+		}
+		h.mutex.Unlock()
+	}
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.persist()
+	return computeStats(h.activities, configZoneRanges()), nil
+}
+
+// fetch every activity that isn't private from the athlete's history
+func fetchHistory(ctx context.Context, tokens *tokens) ([]historyActivity, error) {
+	var activities []historyActivity
+	for page := 1; ; page++ {
+		stravaActivities, err := sendStravaAPIRequest[[]stravaActivity](
+			ctx,
+			fmt.Sprintf("api/v3/athlete/activities?per_page=%d&page=%d", historyPageSize, page),
+			tokens,
+		)
+		if err != nil {
+			return nil, err
+		}
+		for _, a := range stravaActivities {
+			if !a.Private {
+				activities = append(activities, newHistoryActivity(a))
+			}
+		}
+		if len(stravaActivities) < historyPageSize {
+			break
+		}
+	}
+	sortHistory(activities)
+	return activities, nil
+}
+
+// sort activities from newest to oldest
+func sortHistory(activities []historyActivity) {
+	slices.SortStableFunc(activities, func(a, b historyActivity) int {
+		return b.StartDate.Compare(a.StartDate)
+	})
+}
+
+// record that an event changed an activity so syncs that are fetching the history don't overwrite
+// it. Must be called with the mutex held.
+func (h *history) markChanged(id uint64) {
+	h.applied++
+	if h.syncs == 0 {
+		return
+	}
+	if h.changed == nil {
+		h.changed = map[uint64]uint64{}
+	}
+	h.changed[id] = h.applied
+}
+
+// stop keeping track of changed activities once no sync needs them. Must be called with the mutex
+// held.
+func (h *history) forgetChanges() {
+	if h.syncs == 0 {
+		h.changed = nil
+	}
+}
+
+// if the activity is missing anything that isn't in strava's list of activities
+func (a historyActivity) needsBackfill() bool {
+	return a.Calories == nil || (a.AverageHeartrate > 0 && a.HeartrateHistogram == nil)
+}
+
+// apply a webhook event to the history. details and histogram are the activity's details and
+// heart rate if they were fetched for the event, which they are whenever the activity's type
+// changes as the type in the event isn't its sport type. Returns if the history changed.
+func (h *history) apply(
+	e event,
+	details *detailedStravaActivity,
+	histogram heartrateHistogram,
+) bool {
+	if e.ObjectType != "activity" {
+		return false
+	}
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	id := uint64(e.ObjectID)
+	index := slices.IndexFunc(h.activities, func(a historyActivity) bool { return a.ID == id })
+	switch {
+	case e.AspectType == "delete" || e.Updates["private"] == "true" ||
+		(details != nil && details.Private):
+		// marked even if it isn't in the history yet so a sync can't add it
+		h.markChanged(id)
+		if index == -1 {
+			return false
+		}
+		h.activities = slices.Delete(h.activities, index, index+1)
+	case details != nil:
+		h.markChanged(id)
+		a := newHistoryActivity(details.stravaActivity)
+		a.Calories = &details.Calories
+		a.HeartrateHistogram = histogram
+		if index != -1 {
+			// heart rate isn't fetched again for events that can't change it
+			if a.HeartrateHistogram == nil {
+				a.HeartrateHistogram = h.activities[index].HeartrateHistogram
+			}
+			h.activities = slices.Delete(h.activities, index, index+1)
+		}
+		index = slices.IndexFunc(h.activities, func(b historyActivity) bool {
+			return b.StartDate.Before(a.StartDate)
+		})
+		if index == -1 {
+			index = len(h.activities)
+		}
+		h.activities = slices.Insert(h.activities, index, a)
+	default:
+		return false
+	}
+	h.persist()
+	return true
+}
+
+// compute the stats for the current history
+func (h *history) stats() stats {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	return computeStats(h.activities, configZoneRanges())
+}
+
+// copy of every activity in the history
+func (h *history) list() []historyActivity {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	return slices.Clone(h.activities)
+}
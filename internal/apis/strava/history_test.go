@@ -0,0 +1,78 @@
+package strava
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+
+	"pkg.mattglei.ch/lcp-2/internal/config"
+	"pkg.mattglei.ch/lcp-2/internal/secrets"
+)
+
+func TestHistorySyncKeepsEventsAppliedWhileFetching(t *testing.T) {
+	prevSecrets := secrets.Get()
+	s := prevSecrets
+	s.CacheFolder = t.TempDir()
+	secrets.Set(s)
+	t.Cleanup(func() { secrets.Set(prevSecrets) })
+
+	calories := float32(100)
+	h := &history{activities: []historyActivity{
+		{ID: 2, StartDate: time.Date(2026, 2, 1, 0, 0, 0, 0, time.UTC), Calories: &calories},
+		{ID: 1, StartDate: time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC), Calories: &calories},
+	}}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v3/athlete/activities" {
+			http.NotFound(w, r)
+			return
+		}
+		// events for a new activity and a deleted one are handled while the page is fetched, which
+		// doesn't have either change yet
+		created := detailedStravaActivity{Calories: calories}
+		created.ID = 3
+		created.StartDate = time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+		h.apply(event{ObjectType: "activity", ObjectID: 3, AspectType: "create"}, &created, nil)
+		h.apply(event{ObjectType: "activity", ObjectID: 2, AspectType: "delete"}, nil, nil)
+
+		w.Header().Set("Content-Type", "application/json")
+		err := json.NewEncoder(w).Encode([]map[string]any{
+			{"id": 2, "start_date": "2026-02-01T00:00:00Z"},
+			{"id": 1, "start_date": "2026-01-01T00:00:00Z"},
+		})
+		if err != nil {
+			t.Error(err)
+		}
+	}))
+	t.Cleanup(server.Close)
+	prevConfig := config.Get()
+	conf := config.Defaults()
+	conf.Upstreams.Strava = server.URL
+	config.Set(conf)
+	t.Cleanup(func() { config.Set(prevConfig) })
+
+	stravaTokens := &tokens{set: tokenSet{
+		Access:    "access",
+		Refresh:   "refresh",
+		ExpiresAt: time.Now().Add(time.Hour).Unix(),
+	}}
+	_, err := h.sync(context.Background(), stravaTokens)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var ids []uint64
+	for _, a := range h.list() {
+		ids = append(ids, a.ID)
+	}
+	if !slices.Equal(ids, []uint64{3, 1}) {
+		t.Errorf("got activities %v after the sync, expected [3 1]", ids)
+	}
+	if h.changed != nil {
+		t.Error("changes are still tracked after the sync finished")
+	}
+}
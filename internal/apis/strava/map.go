@@ -6,71 +6,90 @@ import (
 	"fmt"
 	"net/http"
 	"net/url"
+	"strings"
 
 	"github.com/gleich/lumber/v3"
 	"github.com/minio/minio-go/v7"
+	"pkg.mattglei.ch/lcp-2/internal/apis"
+	"pkg.mattglei.ch/lcp-2/internal/config"
 	"pkg.mattglei.ch/lcp-2/internal/secrets"
 )
 
 const bucketName = "mapbox-maps"
 
-func fetchMap(polyline string) []byte {
+// fetch an image of the route from the Mapbox Static Images API
+func fetchMap(ctx context.Context, polyline string, style config.MapStyle) ([]byte, error) {
 	var (
-		lineWidth = 2.0
-		lineColor = "000"
-		width     = 440
-		height    = 240
-		params    = url.Values{"access_token": {secrets.SECRETS.MapboxAccessToken}}
+		width  = 440
+		height = 240
+		params = url.Values{"access_token": {secrets.Get().MapboxAccessToken}}
 	)
 	url := fmt.Sprintf(
-		"https://api.mapbox.com/styles/v1/mattgleich/clxxsfdfm002401qj7jcxh47e/static/path-%f+%s(%s)/auto/%dx%d@2x?%s",
-		lineWidth,
-		lineColor,
+		"%s/styles/v1/%s/static/path-%f+%s(%s)/auto/%dx%d@2x?%s",
+		strings.TrimRight(config.Get().Upstreams.Mapbox, "/"),
+		config.Get().Strava.MapboxStyle,
+		style.StrokeWidth,
+		strings.TrimPrefix(style.StrokeColor, "#"),
 		url.QueryEscape(polyline),
 		width,
 		height,
 		params.Encode(),
 	)
-	resp, err := http.Get(url)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
 	if err != nil {
-		lumber.Error(err, "failed to fetch mapbox image with polyline", url)
-		return nil
+		return nil, fmt.Errorf("%w failed to create request for mapbox image", err)
 	}
+	resp, err := apis.Client.Do(req)
+	if err != nil {
+		return nil, apis.NewSendError(req, err)
+	}
+	defer resp.Body.Close()
 
 	var b bytes.Buffer
 	_, err = b.ReadFrom(resp.Body)
 	if err != nil {
-		lumber.Error(err, "failed to read data from request")
-		return nil
+		return nil, apis.NewSendError(req, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, apis.NewStatusError(req, resp.StatusCode, b.Bytes())
 	}
 
-	return b.Bytes()
+	return b.Bytes(), nil
 }
 
-func uploadMap(minioClient minio.Client, id uint64, data []byte) {
+func uploadMap(
+	ctx context.Context,
+	minioClient minio.Client,
+	key string,
+	contentType string,
+	data []byte,
+) {
 	reader := bytes.NewReader(data)
 	size := int64(len(data))
 
 	_, err := minioClient.PutObject(
-		context.Background(),
+		ctx,
 		bucketName,
-		fmt.Sprintf("%d.png", id),
+		key,
 		reader,
 		size,
-		minio.PutObjectOptions{ContentType: "image/png"},
+		minio.PutObjectOptions{ContentType: contentType},
 	)
 	if err != nil {
 		lumber.Error(err, "failed to upload to minio")
 	}
 }
 
-func removeOldMaps(minioClient minio.Client, activities []activity) {
+func removeOldMaps(ctx context.Context, minioClient minio.Client, activities []activity) {
 	var validKeys []string
 	for _, activity := range activities {
 		validKeys = append(validKeys, fmt.Sprintf("%d.png", activity.ID))
+		if activity.MapSVGURL != nil {
+			validKeys = append(validKeys, fmt.Sprintf("%d.svg", activity.ID))
+		}
 	}
 
-	objects := minioClient.ListObjects(context.Background(), bucketName, minio.ListObjectsOptions{})
+	objects := minioClient.ListObjects(ctx, bucketName, minio.ListObjectsOptions{})
 	for object := range objects {
 		if object.Err != nil {
 			lumber.Error(object.Err, "failed to load object")
@@ -85,7 +104,7 @@ func removeOldMaps(minioClient minio.Client, activities []activity) {
 		}
 		if !validObject {
 			err := minioClient.RemoveObject(
-				context.Background(),
+				ctx,
 				bucketName,
 				object.Key,
 				minio.RemoveObjectOptions{},
@@ -0,0 +1,176 @@
+package strava
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gleich/lumber/v3"
+	"pkg.mattglei.ch/lcp-2/internal/apis"
+	"pkg.mattglei.ch/lcp-2/internal/auth"
+	"pkg.mattglei.ch/lcp-2/internal/config"
+	"pkg.mattglei.ch/lcp-2/internal/secrets"
+)
+
+// scope needed to read every activity, including private ones so they can be filtered out
+const oauthScope = "activity:read_all"
+
+// how long an admin has to finish authorizing with strava after starting the flow
+const oauthStateLifetime = 10 * time.Minute
+
+// authorization code flow that replaces the tokens lcp uses with the ones of a newly authorized
+// athlete. Each flow is started by an admin and gets a random state that strava passes back to the
+// callback, so a callback that wasn't started by an admin is rejected.
+type oauthFlow struct {
+	mutex  sync.Mutex
+	states map[string]time.Time // state to when it expires
+	tokens *tokens
+	queue  *eventQueue
+}
+
+func newOAuthFlow(tokens *tokens, queue *eventQueue) *oauthFlow {
+	return &oauthFlow{states: map[string]time.Time{}, tokens: tokens, queue: queue}
+}
+
+type oauthStartResponse struct {
+	AuthorizeURL string    `json:"authorize_url"`
+	Expires      time.Time `json:"expires"`
+}
+
+// start authorizing lcp with strava for admins. The response has the URL that the athlete needs to
+// open to approve access. Strava redirects back to the redirect_uri query parameter, which defaults
+// to the callback route on the host that the request was made to.
+func (f *oauthFlow) serveStart(w http.ResponseWriter, r *http.Request) {
+	if !auth.IsAdmin(w, r) {
+		return
+	}
+	redirectURI := r.URL.Query().Get("redirect_uri")
+	if redirectURI == "" {
+		scheme := "http"
+		if r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https" {
+			scheme = "https"
+		}
+		redirectURI = scheme + "://" + r.Host + "/strava/oauth/callback"
+	}
+
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		lumber.Error(err, "failed to generate strava oauth state")
+		http.Error(w, "failed to start authorization", http.StatusInternalServerError)
+		return
+	}
+	state := hex.EncodeToString(b)
+	expires := time.Now().Add(oauthStateLifetime)
+	f.mutex.Lock()
+	for s, e := range f.states {
+		if time.Now().After(e) {
+			delete(f.states, s)
+		}
+	}
+	f.states[state] = expires
+	f.mutex.Unlock()
+
+	params := url.Values{
+		"client_id":       {secrets.Get().StravaClientID},
+		"redirect_uri":    {redirectURI},
+		"response_type":   {"code"},
+		"approval_prompt": {"force"},
+		"scope":           {oauthScope},
+		"state":           {state},
+	}
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(oauthStartResponse{
+		AuthorizeURL: apis.JoinURL(
+			config.Get().Upstreams.Strava,
+			"oauth/authorize?"+params.Encode(),
+		),
+		Expires: expires,
+	})
+	if err != nil {
+		lumber.Error(err, "failed to write strava authorize URL")
+	}
+}
+
+// finish authorizing lcp with strava by exchanging the code that strava redirected with for tokens.
+// The state has to be one from an admin starting the flow and can only be used once.
+func (f *oauthFlow) serveCallback(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	if !f.consumeState(query.Get("state")) {
+		http.Error(w, "invalid or expired state; start authorizing again", http.StatusForbidden)
+		return
+	}
+	if reason := query.Get("error"); reason != "" {
+		lumber.Warning("strava authorization failed:", reason)
+		http.Error(w, "strava authorization failed: "+reason, http.StatusBadRequest)
+		return
+	}
+	if !slices.Contains(strings.Split(query.Get("scope"), ","), oauthScope) {
+		http.Error(
+			w,
+			"access to all activities ("+oauthScope+") wasn't granted",
+			http.StatusBadRequest,
+		)
+		return
+	}
+	code := query.Get("code")
+	if code == "" {
+		http.Error(w, "missing authorization code", http.StatusBadRequest)
+		return
+	}
+
+	params := url.Values{
+		"client_id":     {secrets.Get().StravaClientID},
+		"client_secret": {secrets.Get().StravaClientSecret},
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+	}
+	req, err := http.NewRequestWithContext(
+		r.Context(),
+		http.MethodPost,
+		apis.JoinURL(config.Get().Upstreams.Strava, "oauth/token"),
+		strings.NewReader(params.Encode()),
+	)
+	if err != nil {
+		lumber.Error(err, "creating request to exchange strava authorization code failed")
+		http.Error(w, "failed to exchange authorization code", http.StatusInternalServerError)
+		return
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	set, err := apis.SendRequest[tokenSet](req)
+	if err != nil {
+		http.Error(w, "failed to exchange authorization code", http.StatusBadGateway)
+		return
+	}
+
+	err = f.tokens.authorize(set)
+	if err != nil {
+		lumber.Error(err, "failed to persist strava tokens")
+	}
+	f.queue.enqueue(authorizedEvent, time.Now())
+	lumber.Done("authorized lcp with strava")
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	_, err = w.Write([]byte("lcp is authorized with strava\n"))
+	if err != nil {
+		lumber.Error(err, "failed to write strava authorization response")
+	}
+}
+
+// check that a state was given out by serveStart and hasn't expired, removing it so it can't be
+// used again
+func (f *oauthFlow) consumeState(state string) bool {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	expires, found := f.states[state]
+	if !found {
+		return false
+	}
+	delete(f.states, state)
+	return time.Now().Before(expires)
+}
@@ -0,0 +1,116 @@
+package strava
+
+import (
+	"errors"
+	"fmt"
+	"math"
+)
+
+// decode a polyline in Google's encoded polyline format into latitude, longitude pairs
+func decodePolyline(encoded string) ([][2]float64, error) {
+	var (
+		points   [][2]float64
+		lat, lng int
+	)
+	for i := 0; i < len(encoded); {
+		for axis := range 2 {
+			var (
+				result int
+				shift  uint
+			)
+			for {
+				if i >= len(encoded) {
+					return nil, errors.New("polyline ends in the middle of a point")
+				}
+				b := int(encoded[i]) - 63
+				i++
+				if b < 0 || b > 63 {
+					return nil, fmt.Errorf("invalid character %q in polyline", encoded[i-1])
+				}
+				result |= (b & 0x1f) << shift
+				shift += 5
+				if b < 0x20 {
+					break
+				}
+			}
+			delta := result >> 1
+			if result&1 != 0 {
+				delta = ^delta
+			}
+			if axis == 0 {
+				lat += delta
+			} else {
+				lng += delta
+			}
+		}
+		points = append(points, [2]float64{float64(lat) / 1e5, float64(lng) / 1e5})
+	}
+	return points, nil
+}
+
+// mean radius of the earth in meters
+const earthRadius = 6371008.8
+
+// simplify a route of latitude, longitude pairs with the Douglas-Peucker algorithm so that no
+// removed point was more than tolerance meters away from the simplified route. The first and last
+// points are always kept.
+func simplifyRoute(points [][2]float64, tolerance float64) [][2]float64 {
+	if tolerance <= 0 || len(points) <= 2 {
+		return points
+	}
+
+	// distances are measured on a flat projection around the middle of the route which is plenty
+	// accurate for the size of an activity
+	minLat, maxLat := points[0][0], points[0][0]
+	for _, p := range points {
+		minLat, maxLat = min(minLat, p[0]), max(maxLat, p[0])
+	}
+	lngScale := math.Cos((minLat+maxLat)/2*math.Pi/180) * earthRadius * math.Pi / 180
+	latScale := earthRadius * math.Pi / 180
+	meters := make([][2]float64, len(points))
+	for i, p := range points {
+		meters[i] = [2]float64{p[1] * lngScale, p[0] * latScale}
+	}
+
+	keep := make([]bool, len(points))
+	keep[0], keep[len(points)-1] = true, true
+	stack := [][2]int{{0, len(points) - 1}}
+	for len(stack) > 0 {
+		first, last := stack[len(stack)-1][0], stack[len(stack)-1][1]
+		stack = stack[:len(stack)-1]
+
+		var (
+			farthest int
+			distance float64
+		)
+		for i := first + 1; i < last; i++ {
+			d := segmentDistance(meters[i], meters[first], meters[last])
+			if d > distance {
+				farthest, distance = i, d
+			}
+		}
+		if distance > tolerance {
+			keep[farthest] = true
+			stack = append(stack, [2]int{first, farthest}, [2]int{farthest, last})
+		}
+	}
+
+	var simplified [][2]float64
+	for i, p := range points {
+		if keep[i] {
+			simplified = append(simplified, p)
+		}
+	}
+	return simplified
+}
+
+// distance from p to the line segment between a and b
+func segmentDistance(p, a, b [2]float64) float64 {
+	dx, dy := b[0]-a[0], b[1]-a[1]
+	lengthSquared := dx*dx + dy*dy
+	if lengthSquared == 0 {
+		return math.Hypot(p[0]-a[0], p[1]-a[1])
+	}
+	t := max(0, min(1, ((p[0]-a[0])*dx+(p[1]-a[1])*dy)/lengthSquared))
+	return math.Hypot(p[0]-(a[0]+t*dx), p[1]-(a[1]+t*dy))
+}
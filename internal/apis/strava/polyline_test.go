@@ -0,0 +1,56 @@
+package strava
+
+import (
+	"math"
+	"testing"
+)
+
+func TestDecodePolyline(t *testing.T) {
+	// example from Google's encoded polyline algorithm format documentation
+	points, err := decodePolyline("_p~iF~ps|U_ulLnnqC_mqNvxq`@")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := [][2]float64{{38.5, -120.2}, {40.7, -120.95}, {43.252, -126.453}}
+	if len(points) != len(expected) {
+		t.Fatalf("got %d points, expected %d", len(points), len(expected))
+	}
+	for i, p := range points {
+		if math.Abs(p[0]-expected[i][0]) > 1e-9 || math.Abs(p[1]-expected[i][1]) > 1e-9 {
+			t.Errorf("point %d is %v, expected %v", i, p, expected[i])
+		}
+	}
+
+	_, err = decodePolyline("_p~iF~ps|")
+	if err == nil {
+		t.Error("expected an error for a truncated polyline")
+	}
+}
+
+func TestSimplifyRoute(t *testing.T) {
+	// roughly 1km north with a 1m wiggle in the middle and a 200m detour east near the end
+	points := [][2]float64{
+		{40, -75},
+		{40.002, -75.00001},
+		{40.004, -75},
+		{40.006, -75},
+		{40.007, -74.9976},
+		{40.009, -75},
+	}
+
+	if simplified := simplifyRoute(points, 0); len(simplified) != len(points) {
+		t.Errorf("tolerance of 0 removed points: %v", simplified)
+	}
+
+	simplified := simplifyRoute(points, 10)
+	expected := [][2]float64{{40, -75}, {40.006, -75}, {40.007, -74.9976}, {40.009, -75}}
+	if len(simplified) != len(expected) {
+		t.Fatalf("got %v, expected %v", simplified, expected)
+	}
+	for i := range expected {
+		if simplified[i] != expected[i] {
+			t.Errorf("got %v, expected %v", simplified, expected)
+			break
+		}
+	}
+}
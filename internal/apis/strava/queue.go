@@ -0,0 +1,308 @@
+package strava
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"maps"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/gleich/lumber/v3"
+	"pkg.mattglei.ch/lcp-2/internal/apis"
+	"pkg.mattglei.ch/lcp-2/internal/auth"
+	"pkg.mattglei.ch/lcp-2/internal/files"
+	"pkg.mattglei.ch/lcp-2/internal/secrets"
+)
+
+const (
+	// most events that are kept in the journal
+	journalSize = 1000
+	// attempts at handling an event before it is dropped
+	maxEventAttempts = 10
+	// wait before the first retry of a failed event. Doubles with every attempt.
+	eventRetryDelay    = 30 * time.Second
+	maxEventRetryDelay = time.Hour
+)
+
+type queuedEvent struct {
+	Event       event     `json:"event"`
+	Received    time.Time `json:"received"`
+	Attempts    int       `json:"attempts"`
+	NextAttempt time.Time `json:"next_attempt"`
+	// changes whenever another event is merged into this one so the worker knows to handle it again
+	Seq uint64 `json:"seq"`
+}
+
+type journalEntry struct {
+	Event    event     `json:"event"`
+	Received time.Time `json:"received"`
+}
+
+// queue of webhook events that are waiting to be handled. Both the queue and a journal of every
+// received event are persisted in the cache folder so nothing is lost on a restart.
+type eventQueue struct {
+	mutex   sync.Mutex
+	pending []queuedEvent
+	journal []journalEntry
+	seq     uint64
+	wake    chan struct{}
+}
+
+func queueFilePath() string {
+	return filepath.Join(secrets.Get().CacheFolder, "strava-events.json")
+}
+
+func journalFilePath() string {
+	return filepath.Join(secrets.Get().CacheFolder, "strava-journal.json")
+}
+
+// load the queue and journal that were persisted before the last shutdown
+func loadEventQueue() *eventQueue {
+	q := &eventQueue{wake: make(chan struct{}, 1)}
+	readQueueFile(queueFilePath(), &q.pending)
+	readQueueFile(journalFilePath(), &q.journal)
+	for _, e := range q.pending {
+		q.seq = max(q.seq, e.Seq)
+	}
+	if len(q.pending) != 0 {
+		lumber.Info("loaded", len(q.pending), "queued strava event(s)")
+	}
+	return q
+}
+
+func readQueueFile(path string, data any) {
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return
+	}
+	if err == nil {
+		err = json.Unmarshal(b, data)
+	}
+	if err != nil {
+		lumber.Error(err, "failed to load", path)
+	}
+}
+
+// record an event in the journal and queue it to be handled
+func (q *eventQueue) receive(e event) {
+	q.mutex.Lock()
+	now := time.Now()
+	q.journal = append(q.journal, journalEntry{Event: e, Received: now})
+	q.journal = q.journal[max(len(q.journal)-journalSize, 0):]
+	q.persist(journalFilePath(), q.journal)
+	q.mutex.Unlock()
+
+	q.enqueue(e, now)
+}
+
+// add an event to the queue. Events for the same object and aspect that are already queued are
+// merged together so they're only handled once.
+func (q *eventQueue) enqueue(e event, received time.Time) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	q.seq++
+	index := slices.IndexFunc(q.pending, func(p queuedEvent) bool {
+		return p.Event.ObjectType == e.ObjectType &&
+			p.Event.ObjectID == e.ObjectID &&
+			p.Event.AspectType == e.AspectType
+	})
+	if index == -1 {
+		q.pending = append(q.pending, queuedEvent{Event: e, Received: received, Seq: q.seq})
+	} else {
+		queued := &q.pending[index]
+		updates := maps.Clone(queued.Event.Updates)
+		if updates == nil {
+			updates = map[string]string{}
+		}
+		maps.Copy(updates, e.Updates)
+		queued.Event.Updates = updates
+		queued.Event.EventTime = max(queued.Event.EventTime, e.EventTime)
+		queued.Attempts = 0
+		queued.NextAttempt = time.Time{}
+		queued.Seq = q.seq
+	}
+	q.persist(queueFilePath(), q.pending)
+
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+// get the next event that can be handled. Events for the same object are handled in the order
+// they were received so one that is waiting to be retried holds back any after it. If there isn't
+// an event to handle, the time until one can be handled is returned (zero if the queue is empty).
+func (q *eventQueue) next() (queuedEvent, bool, time.Duration) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	var (
+		now     = time.Now()
+		wait    time.Duration
+		blocked = map[int64]bool{}
+	)
+	for _, e := range q.pending {
+		if blocked[e.Event.ObjectID] {
+			continue
+		}
+		if e.NextAttempt.After(now) {
+			blocked[e.Event.ObjectID] = true
+			if until := e.NextAttempt.Sub(now); wait == 0 || until < wait {
+				wait = until
+			}
+			continue
+		}
+		return e, true, 0
+	}
+	return queuedEvent{}, false, wait
+}
+
+// record the result of handling an event, scheduling a retry if it failed with an error that
+// could go away
+func (q *eventQueue) done(handled queuedEvent, err error) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	index := slices.IndexFunc(q.pending, func(p queuedEvent) bool { return p.Seq == handled.Seq })
+	if index == -1 {
+		// another event was merged in while this one was being handled so it needs to run again
+		return
+	}
+	queued := &q.pending[index]
+	queued.Attempts++
+	switch {
+	case err == nil:
+		q.pending = slices.Delete(q.pending, index, index+1)
+	case apis.KindOf(err) == apis.Permanent || queued.Attempts >= maxEventAttempts:
+		lumber.Error(
+			err,
+			"dropping strava",
+			queued.Event.ObjectType,
+			queued.Event.AspectType,
+			"event for",
+			queued.Event.ObjectID,
+			"after",
+			queued.Attempts,
+			"attempt(s)",
+		)
+		q.pending = slices.Delete(q.pending, index, index+1)
+	default:
+		delay := min(eventRetryDelay<<(queued.Attempts-1), maxEventRetryDelay)
+		queued.NextAttempt = time.Now().Add(delay)
+		lumber.Warning(
+			"retrying strava",
+			queued.Event.AspectType,
+			"event for",
+			queued.Event.ObjectID,
+			"in",
+			delay,
+		)
+	}
+	q.persist(queueFilePath(), q.pending)
+}
+
+// handle queued events one at a time forever
+func (q *eventQueue) run(handle func(ctx context.Context, e event) error) {
+	for {
+		queued, ok, wait := q.next()
+		if !ok {
+			var timer <-chan time.Time
+			if wait != 0 {
+				timer = time.After(wait)
+			}
+			select {
+			case <-q.wake:
+			case <-timer:
+			}
+			continue
+		}
+		q.done(queued, handle(context.Background(), queued.Event))
+	}
+}
+
+// queue every journaled event that was received at or after since again
+func (q *eventQueue) replay(since time.Time) int {
+	q.mutex.Lock()
+	var entries []journalEntry
+	for _, entry := range q.journal {
+		if !entry.Received.Before(since) {
+			entries = append(entries, entry)
+		}
+	}
+	q.mutex.Unlock()
+
+	for _, entry := range entries {
+		q.enqueue(entry.Event, entry.Received)
+	}
+	return len(entries)
+}
+
+// write part of the queue to disk. Must be called with the mutex held.
+func (q *eventQueue) persist(path string, data any) {
+	b, err := json.Marshal(data)
+	if err != nil {
+		lumber.Error(err, "failed to json marshal", path)
+		return
+	}
+	err = files.WriteAtomic(path, b, 0600)
+	if err != nil {
+		lumber.Error(err, "failed to write", path)
+	}
+}
+
+type eventsResponse struct {
+	Pending []queuedEvent  `json:"pending"`
+	Journal []journalEntry `json:"journal"`
+}
+
+// serve the queued events and the journal of received events to admins
+func (q *eventQueue) serveEvents(w http.ResponseWriter, r *http.Request) {
+	if !auth.IsAdmin(w, r) {
+		return
+	}
+	q.mutex.Lock()
+	response := eventsResponse{
+		Pending: append([]queuedEvent{}, q.pending...),
+		Journal: append([]journalEntry{}, q.journal...),
+	}
+	q.mutex.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(response)
+	if err != nil {
+		lumber.Error(err, "failed to write strava events")
+	}
+}
+
+// queue journaled events again for admins. The since query parameter is an RFC 3339 time that
+// limits the replay to events received after it and defaults to every event in the journal.
+func (q *eventQueue) serveReplay(w http.ResponseWriter, r *http.Request) {
+	if !auth.IsAdmin(w, r) {
+		return
+	}
+	var since time.Time
+	if value := r.URL.Query().Get("since"); value != "" {
+		var err error
+		since, err = time.Parse(time.RFC3339, value)
+		if err != nil {
+			http.Error(w, "since must be an RFC 3339 time", http.StatusBadRequest)
+			return
+		}
+	}
+
+	replayed := q.replay(since)
+	lumber.Info("replaying", replayed, "strava event(s)")
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(struct {
+		Replayed int `json:"replayed"`
+	}{Replayed: replayed})
+	if err != nil {
+		lumber.Error(err, "failed to write replay response")
+	}
+}
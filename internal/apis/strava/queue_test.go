@@ -0,0 +1,63 @@
+package strava
+
+import (
+	"errors"
+	"testing"
+
+	"pkg.mattglei.ch/lcp-2/internal/apis"
+	"pkg.mattglei.ch/lcp-2/internal/secrets"
+)
+
+func TestEventQueue(t *testing.T) {
+	prev := secrets.Get()
+	s := prev
+	s.CacheFolder = t.TempDir()
+	secrets.Set(s)
+	t.Cleanup(func() { secrets.Set(prev) })
+
+	q := loadEventQueue()
+	q.receive(event{
+		ObjectType: "activity",
+		ObjectID:   1,
+		AspectType: "update",
+		Updates:    map[string]string{"title": "a"},
+	})
+	q.receive(event{
+		ObjectType: "activity",
+		ObjectID:   1,
+		AspectType: "update",
+		Updates:    map[string]string{"type": "Run"},
+	})
+	q.receive(event{ObjectType: "activity", ObjectID: 1, AspectType: "delete"})
+	q.receive(event{ObjectType: "activity", ObjectID: 2, AspectType: "create"})
+
+	// updates for the same activity are merged
+	first, ok, _ := q.next()
+	if !ok || first.Event.Updates["title"] != "a" || first.Event.Updates["type"] != "Run" {
+		t.Fatalf("first event wasn't the merged update: %+v", first)
+	}
+
+	// a failed event holds back the rest of the events for its activity
+	q.done(first, &apis.UpstreamError{Kind: apis.Retriable, Err: errors.New("unavailable")})
+	second, ok, _ := q.next()
+	if !ok || second.Event.ObjectID != 2 {
+		t.Fatalf("expected the event for the other activity, got %+v", second)
+	}
+	q.done(second, nil)
+	if _, ok, wait := q.next(); ok || wait <= 0 {
+		t.Fatalf("expected to wait for the retry, got an event or a wait of %v", wait)
+	}
+
+	// queue and journal survive a restart
+	q = loadEventQueue()
+	if len(q.pending) != 2 || len(q.journal) != 4 {
+		t.Fatalf(
+			"got %d pending and %d journaled events after a restart",
+			len(q.pending),
+			len(q.journal),
+		)
+	}
+	if replayed := q.replay(q.journal[3].Received); replayed != 1 || len(q.pending) != 3 {
+		t.Errorf("replayed %d event(s) leaving %d pending", replayed, len(q.pending))
+	}
+}
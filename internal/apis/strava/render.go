@@ -0,0 +1,340 @@
+package strava
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"math"
+	"strconv"
+	"strings"
+
+	"golang.org/x/image/vector"
+	"pkg.mattglei.ch/lcp-2/internal/config"
+)
+
+// size of the maps in CSS pixels. Every map is rendered at @2x.
+const (
+	mapWidth  = 440
+	mapHeight = 240
+	mapScale  = 2
+)
+
+// number of segments used to approximate the circles for line joins and markers
+const circleSegments = 24
+
+type renderedMap struct {
+	png []byte
+	// only set by renderers that can produce an SVG
+	svg []byte
+}
+
+// render the map for a route with the renderer from the config
+func renderMap(ctx context.Context, polyline string) (renderedMap, error) {
+	style := config.Get().Strava.MapStyle
+	switch renderer := config.Get().Strava.MapRenderer; renderer {
+	case "mapbox":
+		data, err := fetchMap(ctx, polyline, style)
+		if err != nil {
+			return renderedMap{}, err
+		}
+		return renderedMap{png: data}, nil
+	case "local":
+		return renderLocalMap(polyline, style)
+	default:
+		return renderedMap{}, fmt.Errorf("unknown map renderer %q", renderer)
+	}
+}
+
+type mapColors struct {
+	stroke     color.RGBA
+	background *color.RGBA
+	start      color.RGBA
+	end        color.RGBA
+}
+
+// draw the route from a polyline to a PNG and SVG without any upstream
+func renderLocalMap(polyline string, style config.MapStyle) (renderedMap, error) {
+	points, err := decodePolyline(polyline)
+	if err != nil {
+		return renderedMap{}, fmt.Errorf("%w failed to decode polyline", err)
+	}
+	if len(points) == 0 {
+		return renderedMap{}, errors.New("polyline has no points")
+	}
+
+	var colors mapColors
+	colors.stroke, err = parseHexColor(style.StrokeColor)
+	if err != nil {
+		return renderedMap{}, fmt.Errorf("%w for stroke_color", err)
+	}
+	if style.Background != "" {
+		background, err := parseHexColor(style.Background)
+		if err != nil {
+			return renderedMap{}, fmt.Errorf("%w for background", err)
+		}
+		colors.background = &background
+	}
+	if style.Markers {
+		colors.start, err = parseHexColor(style.StartColor)
+		if err != nil {
+			return renderedMap{}, fmt.Errorf("%w for start_color", err)
+		}
+		colors.end, err = parseHexColor(style.EndColor)
+		if err != nil {
+			return renderedMap{}, fmt.Errorf("%w for end_color", err)
+		}
+	}
+
+	pixels, err := projectRoute(points, style)
+	if err != nil {
+		return renderedMap{}, err
+	}
+	pngData, err := drawPNG(pixels, style, colors)
+	if err != nil {
+		return renderedMap{}, fmt.Errorf("%w failed to encode map png", err)
+	}
+	return renderedMap{png: pngData, svg: drawSVG(pixels, style, colors)}, nil
+}
+
+// project latitude, longitude pairs with web mercator (like mapbox does) and fit them into the
+// rendered image. The returned points are in pixels of the @2x image.
+func projectRoute(points [][2]float64, style config.MapStyle) ([][2]float64, error) {
+	var (
+		width     = float64(mapWidth * mapScale)
+		height    = float64(mapHeight * mapScale)
+		padding   = style.Padding * mapScale
+		projected = make([][2]float64, len(points))
+		minX      = math.Inf(1)
+		minY      = math.Inf(1)
+		maxX      = math.Inf(-1)
+		maxY      = math.Inf(-1)
+	)
+	if padding < 0 || padding*2 >= min(width, height) {
+		return nil, fmt.Errorf("padding of %v doesn't leave any room for the route", style.Padding)
+	}
+
+	for i, p := range points {
+		lat := max(min(p[0], 85), -85) * math.Pi / 180
+		// y is flipped so north is up in the image
+		x := p[1] * math.Pi / 180
+		y := -math.Log(math.Tan(math.Pi/4 + lat/2))
+		projected[i] = [2]float64{x, y}
+		minX, maxX = min(minX, x), max(maxX, x)
+		minY, maxY = min(minY, y), max(maxY, y)
+	}
+
+	var (
+		spanX  = maxX - minX
+		spanY  = maxY - minY
+		scaleX = axisScale(width-padding*2, spanX)
+		scaleY = axisScale(height-padding*2, spanY)
+	)
+	switch style.Fit {
+	case "contain":
+		scaleX = min(scaleX, scaleY)
+		scaleY = scaleX
+	case "fill":
+	default:
+		return nil, fmt.Errorf("unknown map fit %q", style.Fit)
+	}
+	if math.IsInf(scaleX, 1) {
+		// every point is in the same place
+		scaleX, scaleY = 0, 0
+	}
+	if math.IsInf(scaleY, 1) {
+		scaleY = 0
+	}
+
+	// route is centered on any axis that it doesn't fill
+	offsetX := (width - spanX*scaleX) / 2
+	offsetY := (height - spanY*scaleY) / 2
+	for i, p := range projected {
+		projected[i] = [2]float64{
+			offsetX + (p[0]-minX)*scaleX,
+			offsetY + (p[1]-minY)*scaleY,
+		}
+	}
+	return projected, nil
+}
+
+// pixels per projected unit for an axis, infinite if the route doesn't span the axis
+func axisScale(available, span float64) float64 {
+	if span == 0 {
+		return math.Inf(1)
+	}
+	return available / span
+}
+
+func drawPNG(pixels [][2]float64, style config.MapStyle, colors mapColors) ([]byte, error) {
+	bounds := image.Rect(0, 0, mapWidth*mapScale, mapHeight*mapScale)
+	img := image.NewRGBA(bounds)
+	if colors.background != nil {
+		draw.Draw(img, bounds, image.NewUniform(*colors.background), image.Point{}, draw.Src)
+	}
+
+	// the stroke is drawn as a rectangle for every segment and a circle at every point for round
+	// joins and caps. Every shape is wound the same way so overlaps don't cancel out.
+	halfWidth := style.StrokeWidth * mapScale / 2
+	stroke := vector.NewRasterizer(bounds.Dx(), bounds.Dy())
+	for i, p := range pixels {
+		addCircle(stroke, p, halfWidth)
+		if i == 0 {
+			continue
+		}
+		prev := pixels[i-1]
+		dx, dy := p[0]-prev[0], p[1]-prev[1]
+		length := math.Hypot(dx, dy)
+		if length == 0 {
+			continue
+		}
+		nx, ny := -dy/length*halfWidth, dx/length*halfWidth
+		stroke.MoveTo(float32(prev[0]+nx), float32(prev[1]+ny))
+		stroke.LineTo(float32(p[0]+nx), float32(p[1]+ny))
+		stroke.LineTo(float32(p[0]-nx), float32(p[1]-ny))
+		stroke.LineTo(float32(prev[0]-nx), float32(prev[1]-ny))
+		stroke.ClosePath()
+	}
+	stroke.Draw(img, bounds, image.NewUniform(colors.stroke), image.Point{})
+
+	if style.Markers {
+		radius := markerRadius(style) * mapScale
+		for _, marker := range []struct {
+			point [2]float64
+			color color.RGBA
+		}{
+			{point: pixels[0], color: colors.start},
+			{point: pixels[len(pixels)-1], color: colors.end},
+		} {
+			// white outline keeps the marker visible on top of the route
+			for _, circle := range []struct {
+				radius float64
+				color  color.RGBA
+			}{
+				{radius: radius + mapScale, color: color.RGBA{R: 255, G: 255, B: 255, A: 255}},
+				{radius: radius, color: marker.color},
+			} {
+				r := vector.NewRasterizer(bounds.Dx(), bounds.Dy())
+				addCircle(r, marker.point, circle.radius)
+				r.Draw(img, bounds, image.NewUniform(circle.color), image.Point{})
+			}
+		}
+	}
+
+	var b bytes.Buffer
+	err := png.Encode(&b, img)
+	if err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+// add a circle to the rasterizer wound the same way as the stroke segments
+func addCircle(r *vector.Rasterizer, center [2]float64, radius float64) {
+	for i := range circleSegments {
+		angle := -2 * math.Pi * float64(i) / circleSegments
+		x := float32(center[0] + radius*math.Cos(angle))
+		y := float32(center[1] + radius*math.Sin(angle))
+		if i == 0 {
+			r.MoveTo(x, y)
+		} else {
+			r.LineTo(x, y)
+		}
+	}
+	r.ClosePath()
+}
+
+func drawSVG(pixels [][2]float64, style config.MapStyle, colors mapColors) []byte {
+	var (
+		b      strings.Builder
+		width  = mapWidth * mapScale
+		height = mapHeight * mapScale
+	)
+	fmt.Fprintf(
+		&b,
+		`<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d">`,
+		width,
+		height,
+		width,
+		height,
+	)
+	if colors.background != nil {
+		fmt.Fprintf(&b, `<rect width="100%%" height="100%%" fill="%s"/>`, hexColor(*colors.background))
+	}
+
+	b.WriteString(`<polyline points="`)
+	for i, p := range pixels {
+		if i > 0 {
+			b.WriteByte(' ')
+		}
+		fmt.Fprintf(&b, "%s,%s", svgNumber(p[0]), svgNumber(p[1]))
+	}
+	if len(pixels) == 1 {
+		// a single point would otherwise not be drawn at all
+		fmt.Fprintf(&b, " %s,%s", svgNumber(pixels[0][0]), svgNumber(pixels[0][1]))
+	}
+	fmt.Fprintf(
+		&b,
+		`" fill="none" stroke="%s" stroke-width="%s" stroke-linecap="round" stroke-linejoin="round"/>`,
+		hexColor(colors.stroke),
+		svgNumber(style.StrokeWidth*mapScale),
+	)
+
+	if style.Markers {
+		radius := markerRadius(style) * mapScale
+		for _, marker := range []struct {
+			point [2]float64
+			color color.RGBA
+		}{
+			{point: pixels[0], color: colors.start},
+			{point: pixels[len(pixels)-1], color: colors.end},
+		} {
+			fmt.Fprintf(
+				&b,
+				`<circle cx="%s" cy="%s" r="%s" fill="%s" stroke="#fff" stroke-width="%d"/>`,
+				svgNumber(marker.point[0]),
+				svgNumber(marker.point[1]),
+				svgNumber(radius+mapScale/2),
+				hexColor(marker.color),
+				mapScale,
+			)
+		}
+	}
+
+	b.WriteString("</svg>\n")
+	return []byte(b.String())
+}
+
+// radius of the start and end markers in CSS pixels
+func markerRadius(style config.MapStyle) float64 {
+	return max(style.StrokeWidth*1.5, 3)
+}
+
+// format a coordinate or size for an SVG rounded to a hundredth of a pixel
+func svgNumber(n float64) string {
+	return strconv.FormatFloat(math.Round(n*100)/100, 'f', -1, 64)
+}
+
+// parse a color in the format of #rgb or #rrggbb
+func parseHexColor(s string) (color.RGBA, error) {
+	hex, found := strings.CutPrefix(s, "#")
+	if !found || (len(hex) != 3 && len(hex) != 6) {
+		return color.RGBA{}, fmt.Errorf("invalid color %q; must be #rgb or #rrggbb", s)
+	}
+	if len(hex) == 3 {
+		hex = string([]byte{hex[0], hex[0], hex[1], hex[1], hex[2], hex[2]})
+	}
+	value, err := strconv.ParseUint(hex, 16, 32)
+	if err != nil {
+		return color.RGBA{}, fmt.Errorf("invalid color %q; must be #rgb or #rrggbb", s)
+	}
+	return color.RGBA{R: uint8(value >> 16), G: uint8(value >> 8), B: uint8(value), A: 255}, nil
+}
+
+func hexColor(c color.RGBA) string {
+	return fmt.Sprintf("#%02x%02x%02x", c.R, c.G, c.B)
+}
@@ -0,0 +1,50 @@
+package strava
+
+import (
+	"bytes"
+	"image/png"
+	"strings"
+	"testing"
+
+	"pkg.mattglei.ch/lcp-2/internal/config"
+)
+
+func TestRenderLocalMap(t *testing.T) {
+	const polyline = "_p~iF~ps|U_ulLnnqC_mqNvxq`@"
+	style := config.Defaults().Strava.MapStyle
+	style.Markers = true
+	rendered, err := renderLocalMap(polyline, style)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	img, err := png.Decode(bytes.NewReader(rendered.png))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if img.Bounds().Dx() != 880 || img.Bounds().Dy() != 480 {
+		t.Errorf("png is %v, expected 880x480", img.Bounds().Size())
+	}
+	points, err := decodePolyline(polyline)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pixels, err := projectRoute(points, style)
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := img.At(int(pixels[0][0]), int(pixels[0][1]))
+	if r, g, b, _ := start.RGBA(); r>>8 != 0x16 || g>>8 != 0xa3 || b>>8 != 0x4a {
+		t.Errorf("start of the route is %v, expected the start marker color", start)
+	}
+	if r, g, b, _ := img.At(0, 0).RGBA(); r != 0xffff || g != 0xffff || b != 0xffff {
+		t.Errorf("corner of the map isn't the background color")
+	}
+
+	svg := string(rendered.svg)
+	for _, element := range []string{`<svg `, `<polyline `, `<circle `, `fill="#16a34a"`} {
+		if !strings.Contains(svg, element) {
+			t.Errorf("svg is missing %s", element)
+		}
+	}
+}
@@ -0,0 +1,148 @@
+package strava
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"time"
+)
+
+// totals for every activity in a period
+type totals struct {
+	Count      int     `json:"count"`
+	Distance   float64 `json:"distance"`    // meters
+	MovingTime uint64  `json:"moving_time"` // seconds
+	Elevation  float64 `json:"elevation"`   // meters
+	// average of the activities with heart rate data weighted by their moving time. null if none of
+	// them have heart rate data.
+	AverageHeartrate *float64 `json:"average_heartrate"`
+	// only includes activities that calories have been fetched for
+	Calories float64 `json:"calories"`
+	// seconds spent in each of the heart rate zones, only including activities that the heart rate
+	// has been fetched for
+	HeartrateZones []float64 `json:"heartrate_zones,omitempty"`
+
+	heartbeats    float64
+	heartrateTime float64
+}
+
+func (t *totals) add(a historyActivity, zones []float64) {
+	t.Count++
+	t.Distance = roundHundredths(t.Distance + float64(a.Distance))
+	t.MovingTime += uint64(a.MovingTime)
+	t.Elevation = roundHundredths(t.Elevation + float64(a.TotalElevationGain))
+	if a.AverageHeartrate > 0 && a.MovingTime > 0 {
+		t.heartbeats += float64(a.AverageHeartrate) * float64(a.MovingTime)
+		t.heartrateTime += float64(a.MovingTime)
+		average := roundHundredths(t.heartbeats / t.heartrateTime)
+		t.AverageHeartrate = &average
+	}
+	if a.Calories != nil {
+		t.Calories = roundHundredths(t.Calories + float64(*a.Calories))
+	}
+	if zones != nil {
+		if t.HeartrateZones == nil {
+			t.HeartrateZones = make([]float64, len(zones))
+		}
+		for i, seconds := range zones {
+			t.HeartrateZones[i] = roundHundredths(t.HeartrateZones[i] + seconds)
+		}
+	}
+}
+
+// round away the noise from adding up strava's single precision values
+func roundHundredths(n float64) float64 {
+	return math.Round(n*100) / 100
+}
+
+// totals for every period that activities happened in. Periods are in the timezone of each
+// activity so an activity late on a sunday counts towards that week wherever it was recorded.
+type periodStats struct {
+	AllTime *totals            `json:"all_time"`
+	Years   map[string]*totals `json:"years"`  // keyed like 2025
+	Months  map[string]*totals `json:"months"` // keyed like 2025-12
+	Weeks   map[string]*totals `json:"weeks"`  // ISO 8601 weeks keyed like 2026-W01
+}
+
+func newPeriodStats() *periodStats {
+	return &periodStats{
+		AllTime: &totals{},
+		Years:   map[string]*totals{},
+		Months:  map[string]*totals{},
+		Weeks:   map[string]*totals{},
+	}
+}
+
+func (p *periodStats) add(a historyActivity, local time.Time, zones []float64) {
+	year, week := local.ISOWeek()
+	p.AllTime.add(a, zones)
+	for _, period := range []struct {
+		totals map[string]*totals
+		key    string
+	}{
+		{totals: p.Years, key: local.Format("2006")},
+		{totals: p.Months, key: local.Format("2006-01")},
+		{totals: p.Weeks, key: fmt.Sprintf("%d-W%02d", year, week)},
+	} {
+		t, ok := period.totals[period.key]
+		if !ok {
+			t = &totals{}
+			period.totals[period.key] = t
+		}
+		t.add(a, zones)
+	}
+}
+
+type stats struct {
+	// zones that the heart rate zone totals are for
+	HeartrateZones []zoneRange `json:"heartrate_zones"`
+	// every sport type together
+	All    *periodStats            `json:"all"`
+	Sports map[string]*periodStats `json:"sports"`
+}
+
+func computeStats(activities []historyActivity, ranges []zoneRange) stats {
+	s := stats{HeartrateZones: ranges, All: newPeriodStats(), Sports: map[string]*periodStats{}}
+	locations := map[string]*time.Location{}
+	for _, a := range activities {
+		location, ok := locations[a.Timezone]
+		if !ok {
+			location = activityLocation(a.Timezone)
+			locations[a.Timezone] = location
+		}
+		local := a.StartDate.In(location)
+		var zones []float64
+		if len(a.HeartrateHistogram) != 0 {
+			zones = zoneSeconds(a.HeartrateHistogram, ranges)
+		}
+
+		s.All.add(a, local, zones)
+		sport, ok := s.Sports[a.SportType]
+		if !ok {
+			sport = newPeriodStats()
+			s.Sports[a.SportType] = sport
+		}
+		sport.add(a, local, zones)
+	}
+	return s
+}
+
+// get the location for a strava timezone like "(GMT-05:00) America/New_York", falling back to the
+// offset if the zone isn't known and UTC if the timezone can't be parsed at all
+func activityLocation(timezone string) *time.Location {
+	offset, name, found := strings.Cut(timezone, ") ")
+	if !found {
+		return time.UTC
+	}
+	location, err := time.LoadLocation(name)
+	if err == nil {
+		return location
+	}
+	offset = strings.TrimPrefix(offset, "(GMT")
+	t, err := time.Parse("-07:00", offset)
+	if err != nil {
+		return time.UTC
+	}
+	_, seconds := t.Zone()
+	return time.FixedZone(offset, seconds)
+}
@@ -0,0 +1,91 @@
+package strava
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"pkg.mattglei.ch/lcp-2/internal/config"
+)
+
+func TestComputeStats(t *testing.T) {
+	calories := float32(500)
+	activities := []historyActivity{
+		{
+			// new year's eve in new york but already new year's day in UTC
+			ID:               1,
+			SportType:        "Run",
+			StartDate:        time.Date(2026, 1, 1, 3, 0, 0, 0, time.UTC),
+			Timezone:         "(GMT-05:00) America/New_York",
+			Distance:         10000,
+			MovingTime:       3000,
+			AverageHeartrate: 150,
+			Calories:         &calories,
+			HeartrateHistogram: heartrateHistogram{
+				100: 1000,
+				175: 2000,
+			},
+		},
+		{
+			// unknown zone falls back to the offset
+			ID:         2,
+			SportType:  "Run",
+			StartDate:  time.Date(2025, 12, 29, 23, 0, 0, 0, time.UTC),
+			Timezone:   "(GMT+02:00) Nowhere/Unknown",
+			Distance:   5000,
+			MovingTime: 1000,
+		},
+		{
+			ID:               3,
+			SportType:        "Ride",
+			StartDate:        time.Date(2025, 12, 1, 12, 0, 0, 0, time.UTC),
+			Timezone:         "(GMT+00:00) Europe/London",
+			Distance:         40000.1,
+			MovingTime:       1000,
+			AverageHeartrate: 130,
+			// heart rate couldn't be fetched
+			HeartrateHistogram: heartrateHistogram{},
+		},
+	}
+	s := computeStats(activities, zoneRanges(config.Heartrate{Max: 190, ZonesFrom: "max"}))
+
+	run := s.Sports["Run"]
+	if run == nil || run.AllTime.Count != 2 || run.AllTime.Distance != 15000 {
+		t.Fatalf("unexpected run totals: %+v", run)
+	}
+	if _, ok := run.Years["2025"]; !ok || len(run.Years) != 1 {
+		t.Errorf("expected runs to be in 2025 where they happened, got %v", run.Years)
+	}
+	// 2025-12-30 in the activity's own timezone is in the first ISO week of 2026
+	if week := run.Weeks["2026-W01"]; week == nil || week.Count != 2 {
+		t.Errorf("expected both runs in 2026-W01, got %v", run.Weeks)
+	}
+	if run.AllTime.AverageHeartrate == nil || *run.AllTime.AverageHeartrate != 150 {
+		t.Errorf("activities without heart rate should be left out of the average")
+	}
+	if run.AllTime.Calories != 500 {
+		t.Errorf("got %v calories, expected 500", run.AllTime.Calories)
+	}
+
+	all := s.All.AllTime
+	if all.Count != 3 || all.Distance != 55000.1 || all.MovingTime != 5000 {
+		t.Errorf("unexpected totals for every sport: %+v", all)
+	}
+	// weighted by moving time
+	if all.AverageHeartrate == nil || *all.AverageHeartrate != 145 {
+		t.Errorf("got average heart rate of %v, expected 145", all.AverageHeartrate)
+	}
+	if s.All.Months["2025-12"].Count != 3 {
+		t.Errorf("expected every activity in 2025-12, got %v", s.All.Months)
+	}
+	if s.Sports["Ride"].AllTime.AverageHeartrate == nil {
+		t.Error("ride is missing its average heart rate")
+	}
+
+	if !slices.Equal(run.Weeks["2026-W01"].HeartrateZones, []float64{1000, 0, 0, 0, 2000}) {
+		t.Errorf("unexpected heart rate zones: %v", run.Weeks["2026-W01"].HeartrateZones)
+	}
+	if s.Sports["Ride"].AllTime.HeartrateZones != nil {
+		t.Error("ride without any heart rate in its histogram has heart rate zones")
+	}
+}
@@ -1,38 +1,105 @@
 package strava
 
 import (
+	"context"
 	"net/http"
+	"time"
 
 	"github.com/gleich/lumber/v3"
 	"github.com/minio/minio-go/v7"
 	"github.com/minio/minio-go/v7/pkg/credentials"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"pkg.mattglei.ch/lcp-2/internal/apis"
 	"pkg.mattglei.ch/lcp-2/internal/cache"
+	"pkg.mattglei.ch/lcp-2/internal/config"
 	"pkg.mattglei.ch/lcp-2/internal/secrets"
 )
 
+// minio credentials provider that always uses the latest secrets so they can be rotated without a
+// restart
+type minioCredentials struct{}
+
+func (minioCredentials) Retrieve() (credentials.Value, error) {
+	return credentials.Value{
+		AccessKeyID:     secrets.Get().MinioAccessKeyID,
+		SecretAccessKey: secrets.Get().MinioSecretKey,
+		SignerType:      credentials.SignatureV4,
+	}, nil
+}
+
+func (c minioCredentials) RetrieveWithCredContext(
+	*credentials.CredContext,
+) (credentials.Value, error) {
+	return c.Retrieve()
+}
+
+func (minioCredentials) IsExpired() bool {
+	return true
+}
+
+// create a client for the bucket that maps are stored in. A nil transport uses minio's default.
+func newMinioClient(transport http.RoundTripper) (*minio.Client, error) {
+	return minio.New(secrets.Get().MinioEndpoint, &minio.Options{
+		Creds:     credentials.New(minioCredentials{}),
+		Secure:    config.Get().Strava.MinioSecure,
+		Transport: transport,
+	})
+}
+
 func Setup(mux *http.ServeMux) {
 	stravaTokens := loadTokens()
-	stravaTokens.refreshIfNeeded()
-	minioClient, err := minio.New(secrets.SECRETS.MinioEndpoint, &minio.Options{
-		Creds: credentials.NewStaticV4(
-			secrets.SECRETS.MinioAccessKeyID,
-			secrets.SECRETS.MinioSecretKey,
-			"",
-		),
-		Secure: true,
-	})
+	stravaTokens.refreshIfNeeded(context.Background())
+	minioTransport, err := minio.DefaultTransport(config.Get().Strava.MinioSecure)
+	if err != nil {
+		lumber.Fatal(err, "failed to create minio transport")
+	}
+	minioClient, err := newMinioClient(otelhttp.NewTransport(minioTransport))
 	if err != nil {
 		lumber.Fatal(err, "failed to create minio client")
 	}
-	stravaActivities, err := fetchActivities(*minioClient, stravaTokens)
+	stravaActivities, err := fetchActivities(context.Background(), *minioClient, stravaTokens, nil)
 	if err != nil {
-		lumber.Error(err, "failed to load initial data for strava cache; not updating")
+		apis.LogFailure(err, "failed to load initial data for strava cache; not updating")
 	}
 	stravaCache := cache.New("strava", stravaActivities, err == nil)
+	if err != nil {
+		stravaCache.RecordError(err)
+	}
 
 	mux.HandleFunc("GET /strava", stravaCache.ServeHTTP)
-	mux.HandleFunc("POST /strava/event", eventRoute(stravaCache, *minioClient, stravaTokens))
+	mux.HandleFunc("GET /strava/activities/{id}/geojson", geoJSONRoute(stravaCache))
+	mux.HandleFunc("GET /strava/activities/{id}/streams", streamsRoute(stravaCache))
+	history, synced := loadHistory()
+	statsCache := cache.New("strava-stats", history.stats(), synced)
+	mux.HandleFunc("GET /strava/stats", statsCache.ServeHTTP)
+	mux.HandleFunc("GET /strava/training-load", trainingLoadRoute(history))
+	go func() {
+		if !synced {
+			// the whole history is only fetched on boot the first time
+			s, err := history.sync(context.Background(), stravaTokens)
+			if err != nil {
+				statsCache.RecordError(err)
+			} else {
+				statsCache.Update(s)
+			}
+		}
+		statsCache.UpdatePeriodically(
+			func(ctx context.Context) (stats, error) { return history.sync(ctx, stravaTokens) },
+			func() time.Duration { return config.Get().Strava.StatsInterval },
+		)
+	}()
+
+	queue := loadEventQueue()
+	go queue.run(eventHandler(stravaCache, statsCache, history, *minioClient, stravaTokens))
+
+	mux.HandleFunc("POST /strava/event", eventRoute(queue))
 	mux.HandleFunc("GET /strava/event", challengeRoute)
+	mux.HandleFunc("GET /strava/events", queue.serveEvents)
+	mux.HandleFunc("POST /strava/events/replay", queue.serveReplay)
+
+	oauth := newOAuthFlow(stravaTokens, queue)
+	mux.HandleFunc("GET /strava/oauth/start", oauth.serveStart)
+	mux.HandleFunc("GET /strava/oauth/callback", oauth.serveCallback)
 
 	lumber.Done("setup strava cache")
 }
@@ -0,0 +1,362 @@
+package strava
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/gleich/lumber/v3"
+	"pkg.mattglei.ch/lcp-2/internal/auth"
+	"pkg.mattglei.ch/lcp-2/internal/cache"
+	"pkg.mattglei.ch/lcp-2/internal/config"
+	"pkg.mattglei.ch/lcp-2/internal/files"
+	"pkg.mattglei.ch/lcp-2/internal/secrets"
+)
+
+// number of points in the heart rate data of an activity, matching strava's low resolution streams
+const heartratePoints = 100
+
+// most points that can be requested from the streams endpoint
+const maxStreamPoints = 10000
+
+var streamKeys = []string{
+	"time",
+	"distance",
+	"altitude",
+	"velocity_smooth",
+	"heartrate",
+	"cadence",
+	"watts",
+	"temp",
+}
+
+type floatStream struct {
+	Data []float64 `json:"data"`
+}
+
+type stravaStreams struct {
+	Time           floatStream `json:"time"`
+	Distance       floatStream `json:"distance"`
+	Altitude       floatStream `json:"altitude"`
+	VelocitySmooth floatStream `json:"velocity_smooth"`
+	Heartrate      floatStream `json:"heartrate"`
+	Cadence        floatStream `json:"cadence"`
+	Watts          floatStream `json:"watts"`
+	Temp           floatStream `json:"temp"`
+}
+
+// data recorded during an activity. Every series has a value for each point in time (seconds since
+// the start of the activity) and is left out if the activity doesn't have it.
+type streams struct {
+	Time        []float64 `json:"time"`
+	Distance    []float64 `json:"distance,omitempty"`    // meters
+	Altitude    []float64 `json:"altitude,omitempty"`    // meters
+	Velocity    []float64 `json:"velocity,omitempty"`    // meters per second
+	Heartrate   []float64 `json:"heartrate,omitempty"`   // beats per minute
+	Cadence     []float64 `json:"cadence,omitempty"`     // revolutions or steps per minute
+	Watts       []float64 `json:"watts,omitempty"`       // watts
+	Temperature []float64 `json:"temperature,omitempty"` // degrees celsius
+}
+
+// every series other than time
+func (s *streams) series() []*[]float64 {
+	return []*[]float64{
+		&s.Distance,
+		&s.Altitude,
+		&s.Velocity,
+		&s.Heartrate,
+		&s.Cadence,
+		&s.Watts,
+		&s.Temperature,
+	}
+}
+
+func streamsPath(id uint64) string {
+	return filepath.Join(secrets.Get().CacheFolder, "strava-streams", fmt.Sprintf("%d.json", id))
+}
+
+// get the streams for an activity from the cache folder, fetching them from strava if they haven't
+// been stored yet. Streams don't change after an activity is uploaded so they're only fetched once.
+func loadStreams(ctx context.Context, id uint64, tokens *tokens) (streams, error) {
+	s, err := readStreams(id)
+	if err == nil {
+		return s, nil
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		lumber.Error(err, "failed to read stored streams for", id, "; fetching them again")
+	}
+
+	s, err = fetchStreams(ctx, id, tokens)
+	if err != nil {
+		return streams{}, err
+	}
+	b, err := json.Marshal(s)
+	if err != nil {
+		lumber.Error(err, "failed to json marshal streams for", id)
+		return s, nil
+	}
+	err = files.WriteAtomic(streamsPath(id), b, 0600)
+	if err != nil {
+		lumber.Error(err, "failed to store streams for", id)
+	}
+	return s, nil
+}
+
+func readStreams(id uint64) (streams, error) {
+	b, err := os.ReadFile(streamsPath(id))
+	if err != nil {
+		return streams{}, err
+	}
+	var s streams
+	err = json.Unmarshal(b, &s)
+	if err != nil {
+		return streams{}, err
+	}
+	return s, nil
+}
+
+func fetchStreams(ctx context.Context, id uint64, tokens *tokens) (streams, error) {
+	params := url.Values{
+		"key_by_type": {"true"},
+		"keys":        {strings.Join(streamKeys, ",")},
+		"resolution":  {"high"},
+	}
+	response, err := sendStravaAPIRequest[stravaStreams](
+		ctx,
+		fmt.Sprintf("api/v3/activities/%d/streams?%s", id, params.Encode()),
+		tokens,
+	)
+	if err != nil {
+		return streams{}, err
+	}
+
+	s := streams{
+		Time:        response.Time.Data,
+		Distance:    response.Distance.Data,
+		Altitude:    response.Altitude.Data,
+		Velocity:    response.VelocitySmooth.Data,
+		Heartrate:   response.Heartrate.Data,
+		Cadence:     response.Cadence.Data,
+		Watts:       response.Watts.Data,
+		Temperature: response.Temp.Data,
+	}
+	// every series is indexed the same as time so any that aren't can't be used
+	for _, series := range s.series() {
+		if len(*series) != len(s.Time) {
+			*series = nil
+		}
+	}
+	return s, nil
+}
+
+// remove the stored streams of activities that are no longer cached
+func removeOldStreams(activities []activity) {
+	folder := filepath.Dir(streamsPath(0))
+	entries, err := os.ReadDir(folder)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			lumber.Error(err, "failed to list stored streams")
+		}
+		return
+	}
+	valid := map[string]bool{}
+	for _, a := range activities {
+		valid[filepath.Base(streamsPath(a.ID))] = true
+	}
+	for _, entry := range entries {
+		if !valid[entry.Name()] {
+			err = os.Remove(filepath.Join(folder, entry.Name()))
+			if err != nil {
+				lumber.Error(err, "failed to remove stored streams", entry.Name())
+			}
+		}
+	}
+}
+
+// heart rate of an activity downsampled to heartratePoints
+func heartrateData(s streams) []int {
+	if len(s.Heartrate) == 0 {
+		return nil
+	}
+	indexes := lttb(s.Time, [][]float64{s.Heartrate}, heartratePoints)
+	heartrate := make([]int, len(indexes))
+	for i, index := range indexes {
+		heartrate[i] = int(math.Round(s.Heartrate[index]))
+	}
+	return heartrate
+}
+
+// downsample every series to at most the given number of points while keeping them aligned
+func (s streams) downsample(points int) streams {
+	var series [][]float64
+	for _, values := range s.series() {
+		if len(*values) != 0 {
+			series = append(series, *values)
+		}
+	}
+	indexes := lttb(s.Time, series, points)
+
+	pick := func(values []float64) []float64 {
+		if len(values) == 0 {
+			return nil
+		}
+		picked := make([]float64, len(indexes))
+		for i, index := range indexes {
+			picked[i] = values[index]
+		}
+		return picked
+	}
+	downsampled := streams{Time: pick(s.Time)}
+	for i, values := range downsampled.series() {
+		*values = pick(*s.series()[i])
+	}
+	return downsampled
+}
+
+// pick the indexes of at most threshold points with the Largest-Triangle-Three-Buckets algorithm.
+// The series share x as their axis and the same indexes are picked for all of them by adding up
+// the triangle areas of every series. Each series is scaled by its range first so one with large
+// values (like altitude) doesn't decide the points for the rest.
+func lttb(x []float64, series [][]float64, threshold int) []int {
+	n := len(x)
+	if threshold >= n {
+		indexes := make([]int, n)
+		for i := range indexes {
+			indexes[i] = i
+		}
+		return indexes
+	}
+	if threshold < 3 {
+		// not enough points for any buckets so just keep the ends
+		return []int{0, n - 1}
+	}
+
+	scales := make([]float64, len(series))
+	for i, values := range series {
+		low, high := math.Inf(1), math.Inf(-1)
+		for _, v := range values {
+			low, high = min(low, v), max(high, v)
+		}
+		if high > low {
+			scales[i] = 1 / (high - low)
+		}
+	}
+
+	indexes := make([]int, 0, threshold)
+	indexes = append(indexes, 0)
+	// first and last points are always kept so the rest are split into threshold-2 buckets
+	every := float64(n-2) / float64(threshold-2)
+	a := 0
+	for bucket := range threshold - 2 {
+		start := int(float64(bucket)*every) + 1
+		end := int(float64(bucket+1)*every) + 1
+		nextStart := end
+		nextEnd := min(int(float64(bucket+2)*every)+1, n)
+
+		// third point of every triangle is the average of the next bucket
+		var avgX float64
+		avgY := make([]float64, len(series))
+		for j := nextStart; j < nextEnd; j++ {
+			avgX += x[j]
+			for s, values := range series {
+				avgY[s] += values[j]
+			}
+		}
+		count := float64(nextEnd - nextStart)
+		avgX /= count
+		for s := range avgY {
+			avgY[s] /= count
+		}
+
+		picked, largest := start, -1.0
+		for j := start; j < end; j++ {
+			var area float64
+			for s, values := range series {
+				area += math.Abs(
+					(x[a]-avgX)*(values[j]-values[a])-(x[a]-x[j])*(avgY[s]-values[a]),
+				) * scales[s]
+			}
+			if area > largest {
+				picked, largest = j, area
+			}
+		}
+		indexes = append(indexes, picked)
+		a = picked
+	}
+	return append(indexes, n-1)
+}
+
+type streamsResponse struct {
+	ID             uint64 `json:"id"`
+	OriginalPoints int    `json:"original_points"`
+	streams
+}
+
+// serve the streams of a cached activity downsampled to the number of points in the points query
+// parameter or the stream_points config value
+func streamsRoute(stravaCache *cache.Cache[[]activity]) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !auth.IsAuthorized(w, r) {
+			return
+		}
+		id, err := strconv.ParseUint(r.PathValue("id"), 10, 64)
+		if err != nil {
+			http.Error(w, "invalid activity ID", http.StatusBadRequest)
+			return
+		}
+		points := config.Get().Strava.StreamPoints
+		if value := r.URL.Query().Get("points"); value != "" {
+			points, err = strconv.Atoi(value)
+			if err != nil || points < 2 || points > maxStreamPoints {
+				http.Error(
+					w,
+					fmt.Sprintf("points must be a number from 2 to %d", maxStreamPoints),
+					http.StatusBadRequest,
+				)
+				return
+			}
+		}
+
+		var found bool
+		stravaCache.DataMutex.RLock()
+		for _, a := range stravaCache.Data {
+			if a.ID == id {
+				found = true
+				break
+			}
+		}
+		stravaCache.DataMutex.RUnlock()
+		if !found {
+			http.Error(w, "no streams for activity", http.StatusNotFound)
+			return
+		}
+		s, err := readStreams(id)
+		if errors.Is(err, os.ErrNotExist) {
+			http.Error(w, "no streams for activity", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			lumber.Error(err, "failed to read stored streams for", id)
+			http.Error(w, "failed to read streams", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		err = json.NewEncoder(w).Encode(streamsResponse{
+			ID:             id,
+			OriginalPoints: len(s.Time),
+			streams:        s.downsample(points),
+		})
+		if err != nil {
+			lumber.Error(err, "failed to write streams for activity", id)
+		}
+	})
+}
@@ -0,0 +1,52 @@
+package strava
+
+import (
+	"math"
+	"testing"
+)
+
+func TestDownsampleStreams(t *testing.T) {
+	s := streams{}
+	for i := range 1000 {
+		s.Time = append(s.Time, float64(i))
+		s.Altitude = append(s.Altitude, 100+50*math.Sin(float64(i)/100))
+		s.Velocity = append(s.Velocity, 3+math.Cos(float64(i)/10))
+	}
+	// a spike should always survive downsampling
+	s.Velocity[500] = 20
+
+	downsampled := s.downsample(50)
+	if len(downsampled.Time) != 50 || len(downsampled.Altitude) != 50 ||
+		len(downsampled.Velocity) != 50 {
+		t.Fatalf(
+			"got %d times, %d altitudes, and %d velocities, expected 50 of each",
+			len(downsampled.Time),
+			len(downsampled.Altitude),
+			len(downsampled.Velocity),
+		)
+	}
+	if downsampled.Heartrate != nil {
+		t.Error("missing heart rate series was filled in")
+	}
+	if downsampled.Time[0] != 0 || downsampled.Time[49] != 999 {
+		t.Errorf("first and last points weren't kept: %v", downsampled.Time)
+	}
+	var spike bool
+	for i, time := range downsampled.Time {
+		if downsampled.Altitude[i] != s.Altitude[int(time)] ||
+			downsampled.Velocity[i] != s.Velocity[int(time)] {
+			t.Fatalf("series aren't aligned at time %v", time)
+		}
+		if i > 0 && time <= downsampled.Time[i-1] {
+			t.Fatalf("times aren't increasing: %v", downsampled.Time)
+		}
+		spike = spike || time == 500
+	}
+	if !spike {
+		t.Error("velocity spike was dropped")
+	}
+
+	if all := s.downsample(5000); len(all.Time) != 1000 {
+		t.Errorf("downsampling to more points than there are changed the streams")
+	}
+}
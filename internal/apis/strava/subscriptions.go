@@ -0,0 +1,171 @@
+package strava
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"pkg.mattglei.ch/lcp-2/internal/apis"
+	"pkg.mattglei.ch/lcp-2/internal/config"
+	"pkg.mattglei.ch/lcp-2/internal/secrets"
+)
+
+// push subscription that strava sends webhook events for
+type Subscription struct {
+	ID          int64     `json:"id"`
+	CallbackURL string    `json:"callback_url"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// client credentials that every push subscription request is authenticated with
+func clientCredentials() url.Values {
+	return url.Values{
+		"client_id":     {secrets.Get().StravaClientID},
+		"client_secret": {secrets.Get().StravaClientSecret},
+	}
+}
+
+func subscriptionsURL(path string) string {
+	return apis.JoinURL(config.Get().Upstreams.Strava, "api/v3/push_subscriptions"+path)
+}
+
+// create a push subscription that sends events to callbackURL, which should be lcp's /strava/event
+// route. Strava checks the callback with a challenge before responding so lcp needs to be running
+// and reachable at the URL.
+func Subscribe(ctx context.Context, callbackURL string) (int64, error) {
+	params := clientCredentials()
+	params.Set("callback_url", callbackURL)
+	params.Set("verify_token", secrets.Get().StravaVerifyToken)
+	req, err := http.NewRequestWithContext(
+		ctx,
+		http.MethodPost,
+		subscriptionsURL(""),
+		strings.NewReader(params.Encode()),
+	)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	var created struct {
+		ID int64 `json:"id"`
+	}
+	err = sendSubscriptionRequest(req, &created)
+	if err != nil {
+		return 0, err
+	}
+	return created.ID, nil
+}
+
+// list the push subscriptions for lcp's strava application. Strava only allows one at a time.
+func ListSubscriptions(ctx context.Context) ([]Subscription, error) {
+	req, err := http.NewRequestWithContext(
+		ctx,
+		http.MethodGet,
+		subscriptionsURL("?"+clientCredentials().Encode()),
+		nil,
+	)
+	if err != nil {
+		return nil, err
+	}
+	var subscriptions []Subscription
+	err = sendSubscriptionRequest(req, &subscriptions)
+	if err != nil {
+		return nil, err
+	}
+	return subscriptions, nil
+}
+
+// delete a push subscription so strava stops sending events for it
+func Unsubscribe(ctx context.Context, id int64) error {
+	req, err := http.NewRequestWithContext(
+		ctx,
+		http.MethodDelete,
+		subscriptionsURL(fmt.Sprintf("/%d?%s", id, clientCredentials().Encode())),
+		nil,
+	)
+	if err != nil {
+		return err
+	}
+	return sendSubscriptionRequest(req, nil)
+}
+
+// send a request to the push subscriptions API, which responds with 201 to creations and 204 to
+// deletions. The response is decoded into v unless it's nil.
+func sendSubscriptionRequest(req *http.Request, v any) error {
+	resp, err := apis.Client.Do(req)
+	if err != nil {
+		return apis.NewSendError(req, err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return apis.NewSendError(req, err)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return apis.NewStatusError(req, resp.StatusCode, body)
+	}
+	if v == nil {
+		return nil
+	}
+	err = json.Unmarshal(body, v)
+	if err != nil {
+		return apis.NewRequestError(req, apis.Permanent, err)
+	}
+	return nil
+}
+
+// send the same challenge that strava sends when subscribing to callbackURL and check that it's
+// answered correctly
+func VerifyCallback(ctx context.Context, callbackURL string) error {
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil {
+		return err
+	}
+	challenge := hex.EncodeToString(b)
+
+	u, err := url.Parse(callbackURL)
+	if err != nil {
+		return fmt.Errorf("%w invalid callback URL", err)
+	}
+	params := u.Query()
+	params.Set("hub.mode", "subscribe")
+	params.Set("hub.verify_token", secrets.Get().StravaVerifyToken)
+	params.Set("hub.challenge", challenge)
+	u.RawQuery = params.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return err
+	}
+	client := http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("%w failed to send challenge", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("challenge was answered with %s", resp.Status)
+	}
+
+	var answer struct {
+		Challenge string `json:"hub.challenge"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&answer)
+	if err != nil {
+		return fmt.Errorf("%w failed to parse challenge answer", err)
+	}
+	if answer.Challenge != challenge {
+		return fmt.Errorf("challenge was answered with %q instead of %q", answer.Challenge, challenge)
+	}
+	return nil
+}
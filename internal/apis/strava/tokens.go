@@ -1,61 +1,177 @@
 package strava
 
 import (
+	"context"
+	"encoding/json"
 	"errors"
 	"net/http"
 	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/gleich/lumber/v3"
 	"pkg.mattglei.ch/lcp-2/internal/apis"
+	"pkg.mattglei.ch/lcp-2/internal/config"
+	"pkg.mattglei.ch/lcp-2/internal/files"
 	"pkg.mattglei.ch/lcp-2/internal/secrets"
 )
 
-type tokens struct {
+type tokenSet struct {
 	Access    string `json:"access_token"`
 	Refresh   string `json:"refresh_token"`
 	ExpiresAt int64  `json:"expires_at"`
 }
 
-func loadTokens() tokens {
-	return tokens{
-		Access:    secrets.SECRETS.StravaAccessToken,
-		Refresh:   secrets.SECRETS.StravaRefreshToken,
+type tokens struct {
+	mutex sync.Mutex
+	set   tokenSet
+	// set once the athlete revokes lcp's access from their strava settings. It's persisted with the
+	// tokens so lcp stays deauthorized after a restart until it's authorized again.
+	deauthorized bool
+}
+
+// tokens as they're persisted in the cache folder
+type persistedTokens struct {
+	tokenSet
+	Deauthorized bool `json:"deauthorized,omitempty"`
+}
+
+func tokensFilePath() string {
+	return filepath.Join(secrets.Get().CacheFolder, "strava-tokens.enc")
+}
+
+// load the tokens that were persisted from the last refresh, falling back to the tokens from the
+// environment if there aren't any
+func loadTokens() *tokens {
+	persisted, err := readTokens()
+	if err == nil {
+		if persisted.Deauthorized {
+			lumber.Warning("strava athlete revoked access; authorize lcp with /strava/oauth/start")
+		} else {
+			lumber.Done("loaded persisted strava tokens")
+		}
+		return &tokens{set: persisted.tokenSet, deauthorized: persisted.Deauthorized}
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		lumber.Error(err, "failed to load persisted strava tokens; falling back to env")
+	}
+	return &tokens{set: tokenSet{
+		Access:    secrets.Get().StravaAccessToken,
+		Refresh:   secrets.Get().StravaRefreshToken,
 		ExpiresAt: 0, // starts at zero to force a refresh on boot
+	}}
+}
+
+func readTokens() (persistedTokens, error) {
+	b, err := os.ReadFile(tokensFilePath())
+	if err != nil {
+		return persistedTokens{}, err
+	}
+	b, err = secrets.Decrypt(secrets.Get().StravaTokensKey, b)
+	if err != nil {
+		return persistedTokens{}, err
 	}
+	var persisted persistedTokens
+	err = json.Unmarshal(b, &persisted)
+	if err != nil {
+		return persistedTokens{}, err
+	}
+	return persisted, nil
 }
 
-func (t *tokens) refreshIfNeeded() {
+func persistTokens(persisted persistedTokens) error {
+	b, err := json.Marshal(persisted)
+	if err != nil {
+		return err
+	}
+	b, err = secrets.Encrypt(secrets.Get().StravaTokensKey, b)
+	if err != nil {
+		return err
+	}
+	return files.WriteAtomic(tokensFilePath(), b, 0600)
+}
+
+// get the current access token
+func (t *tokens) access() string {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return t.set.Access
+}
+
+// stop using the tokens as the athlete revoked lcp's access. They can't be used again so they're
+// replaced with the flag in the cache folder.
+func (t *tokens) deauthorize() error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.set = tokenSet{}
+	t.deauthorized = true
+	return persistTokens(persistedTokens{Deauthorized: true})
+}
+
+// if lcp is still allowed to access the athlete's data
+func (t *tokens) authorized() bool {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return !t.deauthorized
+}
+
+// start using the tokens of a newly authorized athlete, persisting them so they're used after a
+// restart
+func (t *tokens) authorize(set tokenSet) error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.set = set
+	t.deauthorized = false
+	return persistTokens(persistedTokens{tokenSet: set})
+}
+
+func (t *tokens) refreshIfNeeded(ctx context.Context) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if t.deauthorized {
+		return
+	}
+	if t.set.Refresh == "" {
+		lumber.Warning("no strava refresh token; authorize lcp with /strava/oauth/start")
+		return
+	}
+
 	// subtract 60 to ensure that token doesn't expire in the next 60 seconds
-	if t.ExpiresAt-60 >= time.Now().Unix() {
+	if t.set.ExpiresAt-60 >= time.Now().Unix() {
 		return
 	}
 
 	params := url.Values{
-		"client_id":     {secrets.SECRETS.StravaClientID},
-		"client_secret": {secrets.SECRETS.StravaClientSecret},
+		"client_id":     {secrets.Get().StravaClientID},
+		"client_secret": {secrets.Get().StravaClientSecret},
 		"grant_type":    {"refresh_token"},
-		"refresh_token": {t.Refresh},
-		"code":          {secrets.SECRETS.StravaOAuthCode},
+		"refresh_token": {t.set.Refresh},
 	}
-	req, err := http.NewRequest(
+	req, err := http.NewRequestWithContext(
+		ctx,
 		http.MethodPost,
-		"https://www.strava.com/oauth/token?"+params.Encode(),
-		nil,
+		apis.JoinURL(config.Get().Upstreams.Strava, "oauth/token"),
+		strings.NewReader(params.Encode()),
 	)
 	if err != nil {
 		lumber.Error(err, "creating request for new token failed")
 		return
 	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 
-	tokens, err := apis.SendRequest[tokens](req)
+	set, err := apis.SendRequest[tokenSet](req)
 	if err != nil {
-		if !errors.Is(err, apis.WarningError) {
-			lumber.Error(err, "failed to refresh tokens")
-		}
 		return
 	}
 
-	*t = tokens
-	lumber.Done("loaded new strava access token:", t.Access)
+	t.set = set
+	err = persistTokens(persistedTokens{tokenSet: set})
+	if err != nil {
+		lumber.Error(err, "failed to persist strava tokens")
+	}
+	lumber.Done("refreshed strava access token")
 }
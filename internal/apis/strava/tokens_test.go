@@ -0,0 +1,40 @@
+package strava
+
+import (
+	"testing"
+
+	"pkg.mattglei.ch/lcp-2/internal/secrets"
+)
+
+func TestTokensStayDeauthorizedAfterRestart(t *testing.T) {
+	prev := secrets.Get()
+	s := prev
+	s.CacheFolder = t.TempDir()
+	s.StravaTokensKey = "test"
+	secrets.Set(s)
+	t.Cleanup(func() { secrets.Set(prev) })
+
+	stravaTokens := loadTokens()
+	err := stravaTokens.authorize(tokenSet{Access: "access", Refresh: "refresh", ExpiresAt: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = stravaTokens.deauthorize()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stravaTokens = loadTokens()
+	if stravaTokens.authorized() || stravaTokens.access() != "" {
+		t.Fatal("revoked tokens were loaded after a restart")
+	}
+
+	err = stravaTokens.authorize(tokenSet{Access: "new", Refresh: "refresh", ExpiresAt: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stravaTokens = loadTokens()
+	if !stravaTokens.authorized() || stravaTokens.access() != "new" {
+		t.Fatal("tokens from authorizing again weren't loaded after a restart")
+	}
+}
@@ -0,0 +1,179 @@
+package strava
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gleich/lumber/v3"
+	"pkg.mattglei.ch/lcp-2/internal/auth"
+	"pkg.mattglei.ch/lcp-2/internal/config"
+)
+
+// most days that can be requested from the training load endpoint
+const maxTrainingLoadDays = 3650
+
+// where the training stress of an activity came from
+const (
+	stressFromSufferScore = "suffer_score"
+	stressFromTRIMP       = "trimp"
+)
+
+type activityStress struct {
+	ID     uint64  `json:"id"`
+	Stress float64 `json:"stress"`
+	Source string  `json:"source,omitempty"`
+}
+
+type trainingLoadDay struct {
+	Date string `json:"date"`
+	// total training stress of the day's activities
+	Stress float64 `json:"stress"`
+	// fatigue from the last few days of training
+	Acute float64 `json:"acute"`
+	// fitness from the last few weeks of training
+	Chronic float64 `json:"chronic"`
+	// chronic load minus acute load going into the day. Positive when fresh, negative when tired.
+	Form       float64          `json:"form"`
+	Activities []activityStress `json:"activities,omitempty"`
+}
+
+type trainingLoadResponse struct {
+	AcuteDays   float64           `json:"acute_days"`
+	ChronicDays float64           `json:"chronic_days"`
+	Days        []trainingLoadDay `json:"days"`
+}
+
+// training stress of an activity from its suffer score if strava has one, otherwise estimated from
+// its average heart rate with Banister's TRIMP. Activities without either don't add any stress.
+func trainingStress(a historyActivity, heartrate config.Heartrate) (float64, string) {
+	if a.SufferScore > 0 {
+		return float64(a.SufferScore), stressFromSufferScore
+	}
+	if a.AverageHeartrate <= 0 || heartrate.Max <= heartrate.Resting {
+		return 0, ""
+	}
+	reserve := (float64(a.AverageHeartrate) - heartrate.Resting) / (heartrate.Max - heartrate.Resting)
+	reserve = max(min(reserve, 1), 0)
+	minutes := float64(a.MovingTime) / 60
+	return minutes * reserve * 0.64 * math.Exp(1.92*reserve), stressFromTRIMP
+}
+
+// compute the daily training load from the first activity through the given day. Days are in the
+// timezone of each activity.
+func computeTrainingLoad(
+	activities []historyActivity,
+	through time.Time,
+	heartrate config.Heartrate,
+	load config.TrainingLoad,
+) []trainingLoadDay {
+	var (
+		byDate    = map[string][]activityStress{}
+		locations = map[string]*time.Location{}
+		first     time.Time
+	)
+	for _, a := range activities {
+		stress, source := trainingStress(a, heartrate)
+		location, ok := locations[a.Timezone]
+		if !ok {
+			location = activityLocation(a.Timezone)
+			locations[a.Timezone] = location
+		}
+		local := a.StartDate.In(location)
+		day := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, time.UTC)
+		if first.IsZero() || day.Before(first) {
+			first = day
+		}
+		date := day.Format(time.DateOnly)
+		byDate[date] = append(byDate[date], activityStress{
+			ID:     a.ID,
+			Stress: roundHundredths(stress),
+			Source: source,
+		})
+	}
+	if first.IsZero() {
+		return nil
+	}
+
+	var (
+		last         = time.Date(through.Year(), through.Month(), through.Day(), 0, 0, 0, 0, time.UTC)
+		acuteDecay   = 1 - math.Exp(-1/load.AcuteDays)
+		chronicDecay = 1 - math.Exp(-1/load.ChronicDays)
+		acute        float64
+		chronic      float64
+		days         []trainingLoadDay
+	)
+	for day := first; !day.After(last); day = day.AddDate(0, 0, 1) {
+		date := day.Format(time.DateOnly)
+		var stress float64
+		for _, a := range byDate[date] {
+			stress += a.Stress
+		}
+		form := chronic - acute
+		acute += (stress - acute) * acuteDecay
+		chronic += (stress - chronic) * chronicDecay
+		days = append(days, trainingLoadDay{
+			Date:       date,
+			Stress:     roundHundredths(stress),
+			Acute:      roundHundredths(acute),
+			Chronic:    roundHundredths(chronic),
+			Form:       roundHundredths(form),
+			Activities: byDate[date],
+		})
+	}
+	return days
+}
+
+// serve the daily training load for the number of days in the days query parameter (90 by
+// default). The averages are always computed from the start of the history so they're the same no
+// matter how many days are requested.
+func trainingLoadRoute(history *history) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !auth.IsAuthorized(w, r) {
+			return
+		}
+		days := 90
+		if value := r.URL.Query().Get("days"); value != "" {
+			var err error
+			days, err = strconv.Atoi(value)
+			if err != nil || days < 1 || days > maxTrainingLoadDays {
+				http.Error(
+					w,
+					fmt.Sprintf("days must be a number from 1 to %d", maxTrainingLoadDays),
+					http.StatusBadRequest,
+				)
+				return
+			}
+		}
+
+		activities := history.list()
+		// today is in the timezone of the latest activity as that's where the athlete probably is
+		location := time.UTC
+		if len(activities) != 0 {
+			location = activityLocation(activities[0].Timezone)
+		}
+		conf := config.Get().Strava
+		series := computeTrainingLoad(
+			activities,
+			time.Now().In(location),
+			conf.Heartrate,
+			conf.TrainingLoad,
+		)
+		if series == nil {
+			series = []trainingLoadDay{}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		err := json.NewEncoder(w).Encode(trainingLoadResponse{
+			AcuteDays:   conf.TrainingLoad.AcuteDays,
+			ChronicDays: conf.TrainingLoad.ChronicDays,
+			Days:        series[max(len(series)-days, 0):],
+		})
+		if err != nil {
+			lumber.Error(err, "failed to write strava training load")
+		}
+	})
+}
@@ -0,0 +1,62 @@
+package strava
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"pkg.mattglei.ch/lcp-2/internal/config"
+)
+
+func TestComputeTrainingLoad(t *testing.T) {
+	heartrate := config.Heartrate{Resting: 60, Max: 190}
+	activities := []historyActivity{
+		{
+			// late in the evening of january 3rd where it happened
+			ID:         2,
+			StartDate:  time.Date(2026, 1, 4, 2, 0, 0, 0, time.UTC),
+			Timezone:   "(GMT-05:00) America/New_York",
+			MovingTime: 3600,
+			// heart rate reserve of 0.5
+			AverageHeartrate: 125,
+		},
+		{
+			ID:          1,
+			StartDate:   time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC),
+			Timezone:    "(GMT+00:00) Europe/London",
+			MovingTime:  3600,
+			SufferScore: 100,
+		},
+	}
+	days := computeTrainingLoad(
+		activities,
+		time.Date(2026, 1, 5, 9, 0, 0, 0, time.UTC),
+		heartrate,
+		config.TrainingLoad{AcuteDays: 7, ChronicDays: 42},
+	)
+	if len(days) != 5 || days[0].Date != "2026-01-01" || days[4].Date != "2026-01-05" {
+		t.Fatalf("expected every day from 2026-01-01 through 2026-01-05, got %+v", days)
+	}
+
+	if days[0].Stress != 100 || days[0].Activities[0].Source != stressFromSufferScore {
+		t.Errorf("expected the suffer score to be used, got %+v", days[0])
+	}
+	trimp := 60 * 0.5 * 0.64 * math.Exp(1.92*0.5)
+	if days[2].Stress != roundHundredths(trimp) ||
+		days[2].Activities[0].Source != stressFromTRIMP {
+		t.Errorf("expected a TRIMP of %.2f on 2026-01-03, got %+v", trimp, days[2])
+	}
+	if days[1].Stress != 0 || days[1].Activities != nil {
+		t.Errorf("expected a rest day on 2026-01-02, got %+v", days[1])
+	}
+
+	if acute := roundHundredths(100 * (1 - math.Exp(-1.0/7))); days[0].Acute != acute {
+		t.Errorf("got acute load of %v after the first day, expected %v", days[0].Acute, acute)
+	}
+	if days[0].Form != 0 || days[1].Form >= 0 {
+		t.Errorf("expected form to start at zero and drop after training, got %+v", days[:2])
+	}
+	if days[1].Acute >= days[0].Acute || days[1].Chronic >= days[0].Chronic {
+		t.Errorf("loads should decay on a rest day, got %+v", days[:2])
+	}
+}
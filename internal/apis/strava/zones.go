@@ -0,0 +1,205 @@
+package strava
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/url"
+
+	"pkg.mattglei.ch/lcp-2/internal/config"
+)
+
+// gaps between heart rate samples that are longer than this are pauses and aren't counted
+const maxSampleGap = 60
+
+// usual zones as fractions of the heart rate they're relative to
+var defaultZones = map[string][]float64{
+	"max":       {0.6, 0.7, 0.8, 0.9},
+	"threshold": {0.85, 0.9, 0.95, 1},
+}
+
+// seconds spent at every whole heart rate during an activity. Zones are computed from it so they
+// follow changes to the zones in the config without fetching anything again.
+type heartrateHistogram map[int]float64
+
+// heart rates of a zone in beats per minute
+type zoneRange struct {
+	Min float64 `json:"min"`
+	// null for the last zone
+	Max *float64 `json:"max"`
+}
+
+type heartrateZone struct {
+	zoneRange
+	Seconds float64 `json:"seconds"`
+}
+
+// get the heart rate range of every zone from the config, which was already validated when it was
+// read
+func zoneRanges(conf config.Heartrate) []zoneRange {
+	reference := conf.Max
+	if conf.ZonesFrom == "threshold" {
+		reference = conf.Threshold
+	}
+	fractions := conf.Zones
+	if len(fractions) == 0 {
+		fractions = defaultZones[conf.ZonesFrom]
+	}
+
+	ranges := make([]zoneRange, len(fractions)+1)
+	for i, fraction := range fractions {
+		bound := roundHundredths(fraction * reference)
+		ranges[i].Max = &bound
+		ranges[i+1].Min = bound
+	}
+	return ranges
+}
+
+// index of the zone that a heart rate is in
+func zoneIndex(ranges []zoneRange, heartrate float64) int {
+	for i, r := range ranges {
+		if r.Max == nil || heartrate < *r.Max {
+			return i
+		}
+	}
+	return len(ranges) - 1
+}
+
+// add up the seconds spent in every zone
+func zoneSeconds(histogram heartrateHistogram, ranges []zoneRange) []float64 {
+	seconds := make([]float64, len(ranges))
+	for heartrate, s := range histogram {
+		seconds[zoneIndex(ranges, float64(heartrate))] += s
+	}
+	return seconds
+}
+
+// zones from the config
+func configZoneRanges() []zoneRange {
+	return zoneRanges(config.Get().Strava.Heartrate)
+}
+
+// time spent in every zone from the config. nil if the activity doesn't have any heart rate data.
+func heartrateZones(histogram heartrateHistogram) []heartrateZone {
+	if len(histogram) == 0 {
+		return nil
+	}
+	ranges := configZoneRanges()
+	zones := make([]heartrateZone, len(ranges))
+	for i, seconds := range zoneSeconds(histogram, ranges) {
+		zones[i] = heartrateZone{zoneRange: ranges[i], Seconds: roundHundredths(seconds)}
+	}
+	return zones
+}
+
+// build the histogram for an activity from its streams. Every sample counts for the time since the
+// one before it. nil if the streams don't have heart rate.
+func histogramFromStreams(s streams) heartrateHistogram {
+	if len(s.Heartrate) == 0 {
+		return nil
+	}
+	histogram := heartrateHistogram{}
+	for i := 1; i < len(s.Time); i++ {
+		gap := s.Time[i] - s.Time[i-1]
+		if gap <= 0 || gap > maxSampleGap {
+			continue
+		}
+		histogram[int(math.Round(s.Heartrate[i]))] += gap
+	}
+	return histogram
+}
+
+type stravaZones []struct {
+	Type                string `json:"type"`
+	DistributionBuckets []struct {
+		Min  float64 `json:"min"`
+		Max  float64 `json:"max"`
+		Time float64 `json:"time"`
+	} `json:"distribution_buckets"`
+}
+
+// build the histogram for an activity from strava's zones for it, which are used when the activity
+// doesn't have a heart rate stream. Strava only has the time in each of the athlete's strava zones
+// so the time of a zone is put at the heart rate in the middle of it.
+func fetchZonesHistogram(
+	ctx context.Context,
+	id uint64,
+	tokens *tokens,
+) (heartrateHistogram, error) {
+	zones, err := sendStravaAPIRequest[stravaZones](
+		ctx,
+		fmt.Sprintf("api/v3/activities/%d/zones", id),
+		tokens,
+	)
+	if err != nil {
+		return nil, err
+	}
+	histogram := heartrateHistogram{}
+	for _, zone := range zones {
+		if zone.Type != "heartrate" {
+			continue
+		}
+		for _, bucket := range zone.DistributionBuckets {
+			heartrate := bucket.Min
+			// last bucket doesn't have a max
+			if bucket.Max > bucket.Min {
+				heartrate = (bucket.Min + bucket.Max) / 2
+			}
+			histogram[int(math.Round(heartrate))] += bucket.Time
+		}
+	}
+	return histogram, nil
+}
+
+// build the histogram for an activity that isn't cached from its heart rate stream or strava's
+// zones if it doesn't have one. Only the time and heart rate streams are fetched.
+func fetchHeartrateHistogram(
+	ctx context.Context,
+	id uint64,
+	tokens *tokens,
+) (heartrateHistogram, error) {
+	params := url.Values{
+		"key_by_type": {"true"},
+		"keys":        {"time,heartrate"},
+		"resolution":  {"high"},
+	}
+	response, err := sendStravaAPIRequest[stravaStreams](
+		ctx,
+		fmt.Sprintf("api/v3/activities/%d/streams?%s", id, params.Encode()),
+		tokens,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if len(response.Heartrate.Data) == len(response.Time.Data) {
+		histogram := histogramFromStreams(streams{
+			Time:      response.Time.Data,
+			Heartrate: response.Heartrate.Data,
+		})
+		if histogram != nil {
+			return histogram, nil
+		}
+	}
+	return fetchZonesHistogram(ctx, id, tokens)
+}
+
+// build the histogram for an activity whose streams were already loaded, falling back to strava's
+// zones if they couldn't be loaded or don't have heart rate
+func activityHistogram(
+	ctx context.Context,
+	id uint64,
+	s streams,
+	streamsErr error,
+	tokens *tokens,
+) heartrateHistogram {
+	if streamsErr == nil {
+		if histogram := histogramFromStreams(s); histogram != nil {
+			return histogram
+		}
+	}
+	histogram, err := fetchZonesHistogram(ctx, id, tokens)
+	if err != nil {
+		return nil
+	}
+	return histogram
+}
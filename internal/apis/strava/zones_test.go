@@ -0,0 +1,37 @@
+package strava
+
+import (
+	"maps"
+	"testing"
+
+	"pkg.mattglei.ch/lcp-2/internal/config"
+)
+
+func TestHeartrateZones(t *testing.T) {
+	s := streams{
+		Time:      []float64{0, 5, 10, 15, 200, 205},
+		Heartrate: []float64{120, 130.4, 149.6, 150, 180, 181},
+	}
+	histogram := histogramFromStreams(s)
+	// the pause between 15 and 200 seconds isn't counted
+	expected := heartrateHistogram{130: 5, 150: 10, 181: 5}
+	if !maps.Equal(histogram, expected) {
+		t.Fatalf("got histogram %v, expected %v", histogram, expected)
+	}
+
+	ranges := zoneRanges(config.Heartrate{Threshold: 160, ZonesFrom: "threshold"})
+	if len(ranges) != 5 || ranges[0].Min != 0 || *ranges[0].Max != 136 ||
+		ranges[4].Min != 160 || ranges[4].Max != nil {
+		t.Fatalf("unexpected threshold zones: %+v", ranges)
+	}
+	seconds := zoneSeconds(histogram, ranges)
+	// 136, 144, 152 and 160 are where zones 2 through 5 start
+	if seconds[0] != 5 || seconds[2] != 10 || seconds[4] != 5 {
+		t.Errorf("unexpected seconds in zones: %v", seconds)
+	}
+
+	ranges = zoneRanges(config.Heartrate{Max: 200, ZonesFrom: "max", Zones: []float64{0.5, 0.75}})
+	if len(ranges) != 3 || ranges[1].Min != 100 || ranges[2].Min != 150 {
+		t.Errorf("unexpected custom zones: %+v", ranges)
+	}
+}
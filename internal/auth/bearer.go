@@ -1,16 +1,57 @@
 package auth
 
 import (
-	"fmt"
+	"crypto/subtle"
 	"net/http"
+	"strings"
 
 	"pkg.mattglei.ch/lcp-2/internal/secrets"
 )
 
 func IsAuthorized(w http.ResponseWriter, r *http.Request) bool {
-	if r.Header.Get("Authorization") != fmt.Sprintf("Bearer %s", secrets.SECRETS.ValidToken) {
+	if TokenName(r) == "" {
 		w.WriteHeader(http.StatusUnauthorized)
 		return false
 	}
 	return true
 }
+
+// check that the request was made with the admin token, responding with an error if it wasn't
+func IsAdmin(w http.ResponseWriter, r *http.Request) bool {
+	token, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	adminToken := secrets.Get().AdminToken
+	if found && adminToken != "" && tokensMatch(token, adminToken) {
+		return true
+	}
+	if TokenName(r) == "" {
+		w.WriteHeader(http.StatusUnauthorized)
+	} else {
+		w.WriteHeader(http.StatusForbidden)
+	}
+	return false
+}
+
+// get the name of the token that the request was authenticated with. Returns an empty string if
+// the request doesn't have a valid token.
+func TokenName(r *http.Request) string {
+	token, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !found || token == "" {
+		return ""
+	}
+	if secrets.Get().ValidToken != "" && tokensMatch(token, secrets.Get().ValidToken) {
+		return "default"
+	}
+	if secrets.Get().AdminToken != "" && tokensMatch(token, secrets.Get().AdminToken) {
+		return "admin"
+	}
+	for name, validToken := range secrets.Get().NamedTokens {
+		if validToken != "" && tokensMatch(token, validToken) {
+			return name
+		}
+	}
+	return ""
+}
+
+func tokensMatch(a, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
@@ -1,8 +1,8 @@
 package cache
 
 import (
+	"context"
 	"encoding/json"
-	"errors"
 	"fmt"
 	"net/http"
 	"path/filepath"
@@ -13,28 +13,39 @@ import (
 	"github.com/gleich/lumber/v3"
 	"pkg.mattglei.ch/lcp-2/internal/apis"
 	"pkg.mattglei.ch/lcp-2/internal/auth"
+	"pkg.mattglei.ch/lcp-2/internal/metrics"
 	"pkg.mattglei.ch/lcp-2/internal/secrets"
+	"pkg.mattglei.ch/lcp-2/internal/tracing"
 )
 
+// max amount of time that a single periodic update can take
+const refreshTimeout = 5 * time.Minute
+
 type Cache[T any] struct {
 	name      string
 	DataMutex sync.RWMutex
 	Data      T
 	Updated   time.Time
 	filePath  string
+
+	statusMutex sync.RWMutex
+	lastAttempt time.Time
+	lastSuccess time.Time
+	lastErr     error
 }
 
 func New[T any](name string, data T, update bool) *Cache[T] {
-	cache := Cache[T]{
+	cache := &Cache[T]{
 		name:     name,
 		Updated:  time.Now(),
-		filePath: filepath.Join(secrets.SECRETS.CacheFolder, fmt.Sprintf("%s.json", name)),
+		filePath: filepath.Join(secrets.Get().CacheFolder, fmt.Sprintf("%s.json", name)),
 	}
 	cache.loadFromFile()
 	if update {
 		cache.Update(data)
 	}
-	return &cache
+	register(cache)
+	return cache
 }
 
 type CacheResponse[T any] struct {
@@ -70,6 +81,8 @@ func (c *Cache[T]) Update(data T) {
 		lumber.Error(err, "failed to json marshal new data")
 		return
 	}
+	c.recordSuccess()
+	metrics.ObserveUpdate(c.name, len(new))
 
 	if string(old) != string(new) && string(new) != "null" && strings.Trim(string(new), " ") != "" {
 		c.DataMutex.Lock()
@@ -82,14 +95,24 @@ func (c *Cache[T]) Update(data T) {
 	}
 }
 
-func (c *Cache[T]) UpdatePeriodically(update func() (T, error), interval time.Duration) {
+// update the cache forever, waiting the duration returned by interval between each update. interval
+// is called before every update so changes to it are picked up without a restart.
+func (c *Cache[T]) UpdatePeriodically(
+	update func(ctx context.Context) (T, error),
+	interval func() time.Duration,
+) {
 	for {
-		time.Sleep(interval)
-		data, err := update()
+		time.Sleep(interval())
+		start := time.Now()
+		ctx, span := tracing.StartRoot(context.Background(), c.name+" refresh")
+		ctx, cancel := context.WithTimeout(ctx, refreshTimeout)
+		data, err := update(ctx)
+		cancel()
+		tracing.End(span, err)
+		metrics.ObserveRefresh(c.name, time.Since(start), err)
 		if err != nil {
-			if !errors.Is(err, apis.WarningError) {
-				lumber.Error(err, "updating", c.name, "cache failed")
-			}
+			c.RecordError(err)
+			apis.LogFailure(err, "updating", c.name, "cache failed")
 		} else {
 			c.Update(data)
 		}
@@ -0,0 +1,142 @@
+package cache
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gleich/lumber/v3"
+	"pkg.mattglei.ch/lcp-2/internal/apis"
+	"pkg.mattglei.ch/lcp-2/internal/auth"
+)
+
+type State string
+
+const (
+	StateOK          State = "ok"
+	StateDegraded    State = "degraded"     // last update failed with a retriable error
+	StateFailing     State = "failing"      // last update failed with a permanent error
+	StateAuthExpired State = "auth_expired" // credentials need to be rotated
+	StateCircuitOpen State = "circuit_open" // upstream is failing so updates are short-circuited
+)
+
+type Status struct {
+	Name        string       `json:"name"`
+	State       State        `json:"state"`
+	Updated     time.Time    `json:"updated"`
+	LastAttempt time.Time    `json:"last_attempt"`
+	LastSuccess time.Time    `json:"last_success"`
+	Error       *StatusError `json:"error"`
+}
+
+type StatusError struct {
+	Message    string         `json:"message"`
+	Kind       apis.ErrorKind `json:"kind"`
+	Upstream   string         `json:"upstream,omitempty"`
+	StatusCode int            `json:"status_code,omitempty"`
+}
+
+type statusReporter interface {
+	Status() Status
+}
+
+var registry struct {
+	mutex  sync.Mutex
+	caches []statusReporter
+}
+
+func register(c statusReporter) {
+	registry.mutex.Lock()
+	defer registry.mutex.Unlock()
+	registry.caches = append(registry.caches, c)
+}
+
+// get the status of the cache based on its last update attempt
+func (c *Cache[T]) Status() Status {
+	c.statusMutex.RLock()
+	defer c.statusMutex.RUnlock()
+	c.DataMutex.RLock()
+	defer c.DataMutex.RUnlock()
+
+	status := Status{
+		Name:        c.name,
+		State:       StateOK,
+		Updated:     c.Updated,
+		LastAttempt: c.lastAttempt,
+		LastSuccess: c.lastSuccess,
+	}
+	if c.lastErr != nil {
+		status.Error = &StatusError{Message: c.lastErr.Error(), Kind: apis.KindOf(c.lastErr)}
+		var upstreamErr *apis.UpstreamError
+		if errors.As(c.lastErr, &upstreamErr) {
+			status.Error.Upstream = upstreamErr.Upstream
+			status.Error.StatusCode = upstreamErr.StatusCode
+		}
+		switch {
+		case errors.Is(c.lastErr, apis.ErrCircuitOpen):
+			status.State = StateCircuitOpen
+		case status.Error.Kind == apis.Retriable:
+			status.State = StateDegraded
+		case status.Error.Kind == apis.AuthExpired:
+			status.State = StateAuthExpired
+		default:
+			status.State = StateFailing
+		}
+	}
+	return status
+}
+
+// record that an update of the cache failed
+func (c *Cache[T]) RecordError(err error) {
+	c.statusMutex.Lock()
+	defer c.statusMutex.Unlock()
+	c.lastAttempt = time.Now()
+	c.lastErr = err
+}
+
+func (c *Cache[T]) recordSuccess() {
+	c.statusMutex.Lock()
+	defer c.statusMutex.Unlock()
+	c.lastAttempt = time.Now()
+	c.lastSuccess = c.lastAttempt
+	c.lastErr = nil
+}
+
+type statusResponse struct {
+	Caches   []Status             `json:"caches"`
+	Budgets  []apis.Budget        `json:"budgets"`
+	Breakers []apis.BreakerStatus `json:"breakers"`
+}
+
+// serve the status of every cache along with the rate limit budget and circuit breaker of every
+// upstream
+func ServeStatus(w http.ResponseWriter, r *http.Request) {
+	if !auth.IsAuthorized(w, r) {
+		return
+	}
+
+	registry.mutex.Lock()
+	statuses := []Status{}
+	for _, c := range registry.caches {
+		statuses = append(statuses, c.Status())
+	}
+	registry.mutex.Unlock()
+	slices.SortFunc(statuses, func(a, b Status) int { return strings.Compare(a.Name, b.Name) })
+
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(statusResponse{
+		Caches:   statuses,
+		Budgets:  apis.Budgets(),
+		Breakers: apis.Breakers(),
+	})
+	if err != nil {
+		err = fmt.Errorf("%v failed to write json data to request", err)
+		lumber.Error(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,286 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/gleich/lumber/v3"
+)
+
+var current atomic.Pointer[Config]
+
+// get the currently loaded config. Values can change after a reload so they shouldn't be held onto
+// between fetches.
+func Get() Config {
+	c := current.Load()
+	if c == nil {
+		return Defaults()
+	}
+	return *c
+}
+
+type Config struct {
+	GitHub        GitHub        `toml:"github"`
+	Strava        Strava        `toml:"strava"`
+	Steam         Steam         `toml:"steam"`
+	AppleMusic    AppleMusic    `toml:"applemusic"`
+	Upstreams     Upstreams     `toml:"upstreams"`
+	ResponseCache ResponseCache `toml:"response_cache"`
+	Tracing       Tracing       `toml:"tracing"`
+}
+
+// on disk cache for upstream responses that rarely change
+type ResponseCache struct {
+	Enabled bool                `toml:"enabled"`
+	Rules   []ResponseCacheRule `toml:"rules"`
+}
+
+// how long responses from matching endpoints are served from the cache before being requested again
+type ResponseCacheRule struct {
+	// name of the upstream from the upstreams section
+	Upstream string `toml:"upstream"`
+	// pattern for the path after the upstream's base URL in the format used by path.Match
+	Path string        `toml:"path"`
+	TTL  time.Duration `toml:"ttl"`
+}
+
+type Tracing struct {
+	Enabled bool `toml:"enabled"`
+	// either otlp to send spans to Endpoint or stdout to print them for local debugging
+	Exporter string `toml:"exporter"`
+	// OTLP/HTTP traces URL. Headers can be set with OTEL_EXPORTER_OTLP_HEADERS.
+	Endpoint    string  `toml:"endpoint"`
+	SampleRatio float64 `toml:"sample_ratio"`
+}
+
+// base URLs for every upstream API. Useful for pointing lcp at the mock upstreams server.
+type Upstreams struct {
+	GitHub      string `toml:"github"`
+	Strava      string `toml:"strava"`
+	Mapbox      string `toml:"mapbox"`
+	Steam       string `toml:"steam"`
+	SteamAssets string `toml:"steam_assets"`
+	AppleMusic  string `toml:"applemusic"`
+}
+
+type GitHub struct {
+	Enabled     bool          `toml:"enabled"`
+	Interval    time.Duration `toml:"interval"`
+	PinnedRepos int           `toml:"pinned_repos"`
+}
+
+type Strava struct {
+	Enabled     bool   `toml:"enabled"`
+	Activities  int    `toml:"activities"`
+	MapsURL     string `toml:"maps_url"`
+	MapboxStyle string `toml:"mapbox_style"`
+	MinioSecure bool   `toml:"minio_secure"`
+	// either mapbox to use the Mapbox Static Images API or local to draw the route without any
+	// upstream
+	MapRenderer string   `toml:"map_renderer"`
+	MapStyle    MapStyle `toml:"map_style"`
+	// number of points that activity streams are downsampled to unless a request asks for another
+	StreamPoints int `toml:"stream_points"`
+	// how often the athlete's whole history is fetched again for /strava/stats to pick up anything
+	// that webhook events missed
+	StatsInterval time.Duration `toml:"stats_interval"`
+	Heartrate     Heartrate     `toml:"heartrate"`
+	TrainingLoad  TrainingLoad  `toml:"training_load"`
+}
+
+// the athlete's heart rates in beats per minute
+type Heartrate struct {
+	Resting float64 `toml:"resting"`
+	Max     float64 `toml:"max"`
+	// lactate threshold heart rate
+	Threshold float64 `toml:"threshold"`
+	// either max or threshold for the heart rate that zones are relative to
+	ZonesFrom string `toml:"zones_from"`
+	// where every zone after the first starts as a fraction of the zones_from heart rate. Empty for
+	// the usual five zones of whichever heart rate zones_from is.
+	Zones []float64 `toml:"zones"`
+}
+
+// time constants in days of the exponentially weighted averages of daily training stress
+type TrainingLoad struct {
+	AcuteDays   float64 `toml:"acute_days"`
+	ChronicDays float64 `toml:"chronic_days"`
+}
+
+// how routes are drawn on activity maps. Sizes are in CSS pixels for the 440x240 map and are
+// doubled in the rendered @2x image. Colors are hex like #000 or #1a2b3c. Only the stroke is used
+// by the mapbox renderer.
+type MapStyle struct {
+	StrokeWidth float64 `toml:"stroke_width"`
+	StrokeColor string  `toml:"stroke_color"`
+	// empty for a transparent background
+	Background string  `toml:"background"`
+	Padding    float64 `toml:"padding"`
+	// contain keeps the route's aspect ratio and centers it while fill stretches it to the padding
+	// on both axes
+	Fit        string `toml:"fit"`
+	Markers    bool   `toml:"markers"`
+	StartColor string `toml:"start_color"`
+	EndColor   string `toml:"end_color"`
+}
+
+type Steam struct {
+	Enabled      bool          `toml:"enabled"`
+	Interval     time.Duration `toml:"interval"`
+	Games        int           `toml:"games"`
+	Achievements int           `toml:"achievements"`
+}
+
+type AppleMusic struct {
+	Enabled        bool          `toml:"enabled"`
+	Interval       time.Duration `toml:"interval"`
+	RecentlyPlayed int           `toml:"recently_played"`
+	Playlists      []string      `toml:"playlists"`
+}
+
+// config that is used for anything not set in the config file
+func Defaults() Config {
+	return Config{
+		GitHub: GitHub{
+			Enabled:     true,
+			Interval:    1 * time.Minute,
+			PinnedRepos: 6,
+		},
+		Strava: Strava{
+			Enabled:     true,
+			Activities:  5,
+			MapsURL:     "https://minio-api.dev.mattglei.ch/mapbox-maps",
+			MapboxStyle: "mattgleich/clxxsfdfm002401qj7jcxh47e",
+			MinioSecure: true,
+			MapRenderer: "mapbox",
+			MapStyle: MapStyle{
+				StrokeWidth: 2,
+				StrokeColor: "#000",
+				Background:  "#fff",
+				Padding:     20,
+				Fit:         "contain",
+				Markers:     false,
+				StartColor:  "#16a34a",
+				EndColor:    "#dc2626",
+			},
+			StreamPoints:  500,
+			StatsInterval: 24 * time.Hour,
+			Heartrate: Heartrate{
+				Resting:   60,
+				Max:       190,
+				Threshold: 170,
+				ZonesFrom: "max",
+			},
+			TrainingLoad: TrainingLoad{
+				AcuteDays:   7,
+				ChronicDays: 42,
+			},
+		},
+		Steam: Steam{
+			Enabled:      true,
+			Interval:     5 * time.Minute,
+			Games:        10,
+			Achievements: 5,
+		},
+		AppleMusic: AppleMusic{
+			Enabled:        true,
+			Interval:       30 * time.Second,
+			RecentlyPlayed: 10,
+			Playlists: []string{
+				"p.AWXoXPYSLrvpJlY", // alt
+				"p.LV0PX3EIl0EpDLW", // jazz
+				"p.AWXoZoxHLrvpJlY", // chill
+				"p.gek1E8efLa68Adp", // classics
+				"p.V7VYVB0hZo53MQv", // old man
+				"p.qQXLxPLtA75zg8e", // 80s
+				"p.LV0PXNoCl0EpDLW", // divorced dad
+				"p.QvDQE5RIVbAeokL", // PARTY
+				"p.LV0PXL3Cl0EpDLW", // bops
+				"p.6xZaArOsvzb5OML", // focus
+				"p.O1kz7EoFVmvz704", // funk
+				"p.qQXLxPpFA75zg8e", // RAHHHHHHHH
+				"p.qQXLxpDuA75zg8e", // ROCK
+				"p.O1kz7zbsVmvz704", // country
+				"p.QvDQEN0IVbAeokL", // fall
+				// "p.ZOAXAMZF4KMD6ob", // sad girl music
+				// "p.QvDQEebsVbAeokL", // christmas
+			},
+		},
+		Upstreams: Upstreams{
+			GitHub:      "https://api.github.com/graphql",
+			Strava:      "https://www.strava.com",
+			Mapbox:      "https://api.mapbox.com",
+			Steam:       "https://api.steampowered.com",
+			SteamAssets: "https://shared.akamai.steamstatic.com",
+			AppleMusic:  "https://api.music.apple.com",
+		},
+		ResponseCache: ResponseCache{
+			Enabled: true,
+			Rules: []ResponseCacheRule{
+				{
+					Upstream: "steam",
+					Path:     "/ISteamUserStats/GetSchemaForGame/*",
+					TTL:      24 * time.Hour,
+				},
+				{
+					Upstream: "steam_assets",
+					Path:     "/store_item_assets/steam/apps/*/*",
+					TTL:      7 * 24 * time.Hour,
+				},
+				{
+					Upstream: "applemusic",
+					Path:     "/v1/me/library/playlists/*",
+					TTL:      time.Hour,
+				},
+			},
+		},
+		Tracing: Tracing{
+			Enabled:     false,
+			Exporter:    "otlp",
+			Endpoint:    "http://localhost:4318/v1/traces",
+			SampleRatio: 1,
+		},
+	}
+}
+
+// replace the currently loaded config. Used by tests and tools that load the config themselves.
+func Set(c Config) {
+	current.Store(&c)
+}
+
+// path to the config file. Can be overridden with the CONFIG_PATH env var.
+func Path() string {
+	path := os.Getenv("CONFIG_PATH")
+	if path == "" {
+		path = "lcp.toml"
+	}
+	return path
+}
+
+// read and validate the config file on top of the default config. A missing config file isn't an
+// error and just results in the default config.
+func Read(path string) (Config, error) {
+	conf := Defaults()
+	_, err := toml.DecodeFile(path, &conf)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return Config{}, err
+	}
+	err = conf.Validate()
+	if err != nil {
+		return Config{}, err
+	}
+	return conf, nil
+}
+
+func Load() {
+	path := Path()
+	conf, err := Read(path)
+	if err != nil {
+		lumber.Fatal(err, "loading config from", path, "failed")
+	}
+	current.Store(&conf)
+	lumber.Done("loaded config")
+}
@@ -0,0 +1,17 @@
+package config
+
+import (
+	"reflect"
+	"testing"
+)
+
+// copying the example config shouldn't change any of the defaults
+func TestExampleMatchesDefaults(t *testing.T) {
+	conf, err := Read("../../lcp.example.toml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(conf, Defaults()) {
+		t.Errorf("lcp.example.toml doesn't match the defaults:\n%+v\n%+v", conf, Defaults())
+	}
+}
@@ -0,0 +1,117 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+// check that every value in the config can be used and return every problem found. Values that
+// providers slice by or loop on are checked even if the provider is disabled so enabling it later
+// can't fail.
+func (c Config) Validate() error {
+	var errs []error
+	positive := func(name string, d time.Duration) {
+		if d <= 0 {
+			errs = append(errs, fmt.Errorf("%s must be positive, got %s", name, d))
+		}
+	}
+	count := func(name string, n int) {
+		if n < 0 {
+			errs = append(errs, fmt.Errorf("%s can't be negative, got %d", name, n))
+		}
+	}
+	oneOf := func(name, value string, allowed ...string) {
+		if !slices.Contains(allowed, value) {
+			errs = append(errs, fmt.Errorf(
+				"%s must be one of %s, got %q",
+				name,
+				strings.Join(allowed, ", "),
+				value,
+			))
+		}
+	}
+
+	positive("github.interval", c.GitHub.Interval)
+	count("github.pinned_repos", c.GitHub.PinnedRepos)
+
+	count("strava.activities", c.Strava.Activities)
+	oneOf("strava.map_renderer", c.Strava.MapRenderer, "mapbox", "local")
+	oneOf("strava.map_style.fit", c.Strava.MapStyle.Fit, "contain", "fill")
+	if c.Strava.StreamPoints < 2 {
+		errs = append(
+			errs,
+			fmt.Errorf("strava.stream_points must be at least 2, got %d", c.Strava.StreamPoints),
+		)
+	}
+	positive("strava.stats_interval", c.Strava.StatsInterval)
+	oneOf("strava.heartrate.zones_from", c.Strava.Heartrate.ZonesFrom, "max", "threshold")
+	errs = append(errs, c.Strava.Heartrate.validate()...)
+	if c.Strava.TrainingLoad.AcuteDays <= 0 || c.Strava.TrainingLoad.ChronicDays <= 0 {
+		errs = append(errs, errors.New("strava.training_load days must be positive"))
+	}
+
+	positive("steam.interval", c.Steam.Interval)
+	count("steam.games", c.Steam.Games)
+	count("steam.achievements", c.Steam.Achievements)
+
+	positive("applemusic.interval", c.AppleMusic.Interval)
+	count("applemusic.recently_played", c.AppleMusic.RecentlyPlayed)
+
+	for i, rule := range c.ResponseCache.Rules {
+		positive(fmt.Sprintf("response_cache.rules[%d].ttl", i), rule.TTL)
+	}
+
+	oneOf("tracing.exporter", c.Tracing.Exporter, "otlp", "stdout")
+	if c.Tracing.SampleRatio < 0 || c.Tracing.SampleRatio > 1 {
+		errs = append(
+			errs,
+			fmt.Errorf(
+				"tracing.sample_ratio must be between 0 and 1, got %v",
+				c.Tracing.SampleRatio,
+			),
+		)
+	}
+	return errors.Join(errs...)
+}
+
+// check the heart rates and zones so zones can always be computed from them
+func (h Heartrate) validate() []error {
+	var errs []error
+	for _, rate := range []struct {
+		name  string
+		value float64
+	}{
+		{name: "resting", value: h.Resting},
+		{name: "max", value: h.Max},
+		{name: "threshold", value: h.Threshold},
+	} {
+		if rate.value <= 0 {
+			errs = append(
+				errs,
+				fmt.Errorf("strava.heartrate.%s must be positive, got %v", rate.name, rate.value),
+			)
+		}
+	}
+	if h.Resting >= h.Threshold || h.Threshold >= h.Max {
+		errs = append(errs, errors.New("strava.heartrate must have resting < threshold < max"))
+	}
+
+	for i, fraction := range h.Zones {
+		switch {
+		case fraction <= 0:
+			errs = append(errs, fmt.Errorf("strava.heartrate.zones[%d] must be positive", i))
+		// zones above the threshold heart rate are fine but nothing is above the max
+		case h.ZonesFrom == "max" && fraction >= 1:
+			errs = append(
+				errs,
+				fmt.Errorf("strava.heartrate.zones[%d] must be below 1 with zones_from max", i),
+			)
+		case i > 0 && fraction <= h.Zones[i-1]:
+			errs = append(errs, errors.New("strava.heartrate.zones must be in increasing order"))
+		}
+	}
+	return errs
+}
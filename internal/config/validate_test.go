@@ -0,0 +1,33 @@
+package config
+
+import "testing"
+
+func TestValidateHeartrate(t *testing.T) {
+	err := Defaults().Validate()
+	if err != nil {
+		t.Fatalf("defaults are invalid: %v", err)
+	}
+
+	for name, change := range map[string]func(h *Heartrate){
+		"zones out of order": func(h *Heartrate) { h.Zones = []float64{0.8, 0.7} },
+		"zone above max":     func(h *Heartrate) { h.Zones = []float64{0.8, 1.1} },
+		"zone below zero":    func(h *Heartrate) { h.Zones = []float64{-0.1, 0.5} },
+		"unknown zones_from": func(h *Heartrate) { h.ZonesFrom = "resting" },
+		"negative max":       func(h *Heartrate) { h.Max = -1 },
+		"threshold over max": func(h *Heartrate) { h.Threshold = 200 },
+	} {
+		c := Defaults()
+		change(&c.Strava.Heartrate)
+		if c.Validate() == nil {
+			t.Errorf("config with %s was accepted", name)
+		}
+	}
+
+	c := Defaults()
+	c.Strava.Heartrate.ZonesFrom = "threshold"
+	c.Strava.Heartrate.Zones = []float64{0.85, 0.9, 0.95, 1, 1.06}
+	err = c.Validate()
+	if err != nil {
+		t.Errorf("zones above the threshold heart rate were rejected: %v", err)
+	}
+}
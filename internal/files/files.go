@@ -0,0 +1,41 @@
+package files
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// write data to a file by writing to a temporary file in the same folder and then renaming it over
+// the destination so readers never see a partially written file
+func WriteAtomic(path string, data []byte, perm os.FileMode) error {
+	folder := filepath.Dir(path)
+	err := os.MkdirAll(folder, 0700)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(folder, filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write(data)
+	if err != nil {
+		tmp.Close()
+		return err
+	}
+	err = tmp.Sync()
+	if err != nil {
+		tmp.Close()
+		return err
+	}
+	err = tmp.Close()
+	if err != nil {
+		return err
+	}
+	err = os.Chmod(tmp.Name(), perm)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,101 @@
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"pkg.mattglei.ch/lcp-2/internal/auth"
+)
+
+var (
+	requests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "lcp_http_requests_total",
+		Help: "Requests served by route and status.",
+	}, []string{"method", "route", "status"})
+	requestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "lcp_http_request_duration_seconds",
+		Help:    "Time taken to serve requests by route and status.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"method", "route", "status"})
+
+	refreshes = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "lcp_cache_refreshes_total",
+		Help: "Cache refreshes by cache and result (success or failure).",
+	}, []string{"cache", "result"})
+	refreshDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "lcp_cache_refresh_duration_seconds",
+		Help:    "Time taken to refresh each cache.",
+		Buckets: []float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120, 300},
+	}, []string{"cache"})
+	lastSuccess = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "lcp_cache_last_success_timestamp_seconds",
+		Help: "Unix time of the last successful update of each cache.",
+	}, []string{"cache"})
+	payloadSize = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "lcp_cache_payload_bytes",
+		Help: "Size of the JSON encoded data in each cache.",
+	}, []string{"cache"})
+
+	upstreamRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "lcp_upstream_requests_total",
+		Help: "Requests sent to upstream APIs by host and status (error if no response).",
+	}, []string{"host", "status"})
+
+	stravaEvents = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "lcp_strava_webhook_events_total",
+		Help: "Strava webhook events received by object and aspect type.",
+	}, []string{"object_type", "aspect_type"})
+)
+
+// serve the metrics in the prometheus text format
+func ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !auth.IsAuthorized(w, r) {
+		return
+	}
+	promhttp.Handler().ServeHTTP(w, r)
+}
+
+func ObserveRequest(method, route string, status int, duration time.Duration) {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch,
+		http.MethodDelete, http.MethodOptions:
+	default:
+		// clients can send any method so unknown ones are grouped to keep the number of series down
+		method = "other"
+	}
+	code := strconv.Itoa(status)
+	requests.WithLabelValues(method, route, code).Inc()
+	requestDuration.WithLabelValues(method, route, code).Observe(duration.Seconds())
+}
+
+func ObserveRefresh(cache string, duration time.Duration, err error) {
+	result := "success"
+	if err != nil {
+		result = "failure"
+	}
+	refreshes.WithLabelValues(cache, result).Inc()
+	refreshDuration.WithLabelValues(cache).Observe(duration.Seconds())
+}
+
+// record a successful update of a cache with data that is size bytes when encoded
+func ObserveUpdate(cache string, size int) {
+	lastSuccess.WithLabelValues(cache).SetToCurrentTime()
+	payloadSize.WithLabelValues(cache).Set(float64(size))
+}
+
+// record a request that was sent upstream. A status of zero means no response was received.
+func ObserveUpstreamRequest(host string, status int) {
+	code := "error"
+	if status != 0 {
+		code = strconv.Itoa(status)
+	}
+	upstreamRequests.WithLabelValues(host, code).Inc()
+}
+
+func ObserveStravaEvent(objectType, aspectType string) {
+	stravaEvents.WithLabelValues(objectType, aspectType).Inc()
+}
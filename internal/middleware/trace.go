@@ -0,0 +1,13 @@
+package middleware
+
+import (
+	"net/http"
+
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+)
+
+// trace every request that comes through the given handler, continuing the trace from the client's
+// traceparent header if there is one. Log renames the span after the matched route.
+func Trace(next http.Handler) http.Handler {
+	return otelhttp.NewHandler(next, "request")
+}
@@ -0,0 +1,119 @@
+package mock
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type fixtureSong struct {
+	Name             string `json:"name"`
+	Artist           string `json:"artist"`
+	Album            string `json:"album"`
+	DurationInMillis int    `json:"duration_in_millis"`
+	Genre            string `json:"genre"`
+	CatalogID        string `json:"catalog_id"`
+}
+
+const (
+	playlistTracks   = 230
+	playlistPageSize = 100
+)
+
+var slugRegex = regexp.MustCompile(`[^a-z0-9]+`)
+
+func mountAppleMusic(mux *http.ServeMux) {
+	songs := loadFixture[[]fixtureSong]("applemusic_songs.json")
+
+	auth := func(handler http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("Music-User-Token") == "" {
+				writeJSON(w, http.StatusForbidden, map[string]any{
+					"errors": []map[string]string{{"status": "403", "title": "Forbidden"}},
+				})
+				return
+			}
+			if requireBearer(w, r) {
+				handler(w, r)
+			}
+		}
+	}
+
+	mux.HandleFunc(
+		"GET /applemusic/v1/me/recent/played/tracks",
+		auth(func(w http.ResponseWriter, r *http.Request) {
+			data := []map[string]any{}
+			for i, s := range songs {
+				data = append(data, songResponse(s, i))
+				// the real API returns songs that were played more than once multiple times
+				if i == 2 {
+					data = append(data, songResponse(s, i))
+				}
+			}
+			writeJSON(w, http.StatusOK, map[string]any{"data": data})
+		}),
+	)
+	mux.HandleFunc(
+		"GET /applemusic/v1/me/library/playlists/{id}",
+		auth(func(w http.ResponseWriter, r *http.Request) {
+			id := r.PathValue("id")
+			writeJSON(w, http.StatusOK, map[string]any{"data": []map[string]any{{
+				"id":   id,
+				"type": "library-playlists",
+				"attributes": map[string]any{
+					"name":             fmt.Sprintf("Mock Playlist %s", strings.TrimPrefix(id, "p.")),
+					"lastModifiedDate": time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC),
+					"playParams":       map[string]string{"globalId": "pl.u-" + strings.TrimPrefix(id, "p.")},
+				},
+			}}})
+		}),
+	)
+	mux.HandleFunc(
+		"GET /applemusic/v1/me/library/playlists/{id}/tracks",
+		auth(func(w http.ResponseWriter, r *http.Request) {
+			id := r.PathValue("id")
+			offset, _ := strconv.Atoi(r.URL.Query().Get("offset"))
+			end := min(offset+playlistPageSize, playlistTracks)
+			data := []map[string]any{}
+			for i := offset; i < end; i++ {
+				data = append(data, songResponse(songs[i%len(songs)], i))
+			}
+			response := map[string]any{"data": data, "meta": map[string]int{"total": playlistTracks}}
+			if end < playlistTracks {
+				response["next"] = fmt.Sprintf("/v1/me/library/playlists/%s/tracks?offset=%d", id, end)
+			}
+			writeJSON(w, http.StatusOK, response)
+		}),
+	)
+}
+
+func songResponse(s fixtureSong, i int) map[string]any {
+	slug := strings.Trim(slugRegex.ReplaceAllString(strings.ToLower(s.Name), "-"), "-")
+	return map[string]any{
+		"id":   fmt.Sprintf("i.%s%d", strings.ToUpper(slug[:min(len(slug), 4)]), i),
+		"type": "library-songs",
+		"href": fmt.Sprintf("/v1/me/library/songs/i.%d", i),
+		"attributes": map[string]any{
+			"albumName":        s.Album,
+			"genreNames":       []string{s.Genre},
+			"trackNumber":      i%12 + 1,
+			"releaseDate":      "1977-02-04",
+			"durationInMillis": s.DurationInMillis,
+			"artwork": map[string]any{
+				"width":  1200,
+				"height": 1200,
+				"url": fmt.Sprintf(
+					"https://is1-ssl.mzstatic.com/image/thumb/Music/mock/%s/{w}x{h}bb.jpg",
+					s.CatalogID,
+				),
+			},
+			"url":        fmt.Sprintf("https://music.apple.com/us/song/%s/%s", slug, s.CatalogID),
+			"name":       s.Name,
+			"artistName": s.Artist,
+			"playParams": map[string]string{"catalogId": s.CatalogID},
+		},
+	}
+}
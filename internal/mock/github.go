@@ -0,0 +1,17 @@
+package mock
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+func mountGitHub(mux *http.ServeMux) {
+	pinned := loadFixture[json.RawMessage]("github_pinned.json")
+	mux.HandleFunc("POST /github/graphql", func(w http.ResponseWriter, r *http.Request) {
+		if !requireBearer(w, r) {
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write(pinned)
+	})
+}
@@ -0,0 +1,37 @@
+package mock
+
+import (
+	"image"
+	"image/color"
+	"image/png"
+	"net/http"
+
+	"github.com/gleich/lumber/v3"
+)
+
+func mountMapbox(mux *http.ServeMux) {
+	mux.HandleFunc(
+		"GET /mapbox/styles/v1/{user}/{style}/static/{path...}",
+		func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Query().Get("access_token") == "" {
+				writeJSON(w, http.StatusUnauthorized, map[string]string{"message": "Not Authorized - No Token"})
+				return
+			}
+			img := image.NewRGBA(image.Rect(0, 0, 880, 480))
+			for x := range img.Bounds().Dx() {
+				for y := range img.Bounds().Dy() {
+					c := uint8(230)
+					if x%80 == 0 || y%80 == 0 {
+						c = 210
+					}
+					img.Set(x, y, color.RGBA{R: c, G: c, B: c, A: 255})
+				}
+			}
+			w.Header().Set("Content-Type", "image/png")
+			err := png.Encode(w, img)
+			if err != nil {
+				lumber.Error(err, "failed to encode mock map")
+			}
+		},
+	)
+}
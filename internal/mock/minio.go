@@ -0,0 +1,119 @@
+package mock
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/xml"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const mapsBucket = "mapbox-maps"
+
+type s3Object struct {
+	data        []byte
+	contentType string
+	etag        string
+	modified    time.Time
+}
+
+type listBucketResult struct {
+	XMLName     xml.Name    `xml:"http://s3.amazonaws.com/doc/2006-03-01/ ListBucketResult"`
+	Name        string      `xml:"Name"`
+	Prefix      string      `xml:"Prefix"`
+	KeyCount    int         `xml:"KeyCount"`
+	MaxKeys     int         `xml:"MaxKeys"`
+	IsTruncated bool        `xml:"IsTruncated"`
+	Contents    []s3Content `xml:"Contents"`
+}
+
+type s3Content struct {
+	Key          string    `xml:"Key"`
+	LastModified time.Time `xml:"LastModified"`
+	ETag         string    `xml:"ETag"`
+	Size         int       `xml:"Size"`
+	StorageClass string    `xml:"StorageClass"`
+}
+
+// serve an in memory S3 bucket for the strava maps so MINIO_ENDPOINT can point at the mock
+// upstreams server. Only the handful of operations that lcp uses are supported.
+func mountMinio(mux *http.ServeMux) {
+	var (
+		mutex   sync.Mutex
+		objects = map[string]s3Object{}
+	)
+
+	mux.HandleFunc("GET /"+mapsBucket+"/{$}", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/xml")
+		if r.URL.Query().Has("location") {
+			_, _ = io.WriteString(
+				w,
+				xml.Header+`<LocationConstraint xmlns="http://s3.amazonaws.com/doc/2006-03-01/">`+
+					`us-east-1</LocationConstraint>`,
+			)
+			return
+		}
+
+		mutex.Lock()
+		keys := make([]string, 0, len(objects))
+		for key := range objects {
+			keys = append(keys, key)
+		}
+		slices.Sort(keys)
+		result := listBucketResult{Name: mapsBucket, KeyCount: len(keys), MaxKeys: 1000}
+		for _, key := range keys {
+			result.Contents = append(result.Contents, s3Content{
+				Key:          key,
+				LastModified: objects[key].modified,
+				ETag:         objects[key].etag,
+				Size:         len(objects[key].data),
+				StorageClass: "STANDARD",
+			})
+		}
+		mutex.Unlock()
+
+		_, _ = io.WriteString(w, xml.Header)
+		_ = xml.NewEncoder(w).Encode(result)
+	})
+	mux.HandleFunc("PUT /"+mapsBucket+"/{key...}", func(w http.ResponseWriter, r *http.Request) {
+		data, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		sum := md5.Sum(data)
+		etag := `"` + hex.EncodeToString(sum[:]) + `"`
+		mutex.Lock()
+		objects[r.PathValue("key")] = s3Object{
+			data:        data,
+			contentType: r.Header.Get("Content-Type"),
+			etag:        etag,
+			modified:    time.Now().UTC().Truncate(time.Second),
+		}
+		mutex.Unlock()
+		w.Header().Set("ETag", etag)
+	})
+	mux.HandleFunc("GET /"+mapsBucket+"/{key...}", func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		object, ok := objects[r.PathValue("key")]
+		mutex.Unlock()
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", object.contentType)
+		w.Header().Set("Content-Length", strconv.Itoa(len(object.data)))
+		w.Header().Set("ETag", object.etag)
+		_, _ = w.Write(object.data)
+	})
+	mux.HandleFunc("DELETE /"+mapsBucket+"/{key...}", func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		delete(objects, r.PathValue("key"))
+		mutex.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
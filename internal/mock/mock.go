@@ -0,0 +1,127 @@
+package mock
+
+import (
+	"crypto/sha256"
+	"embed"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	"github.com/gleich/lumber/v3"
+)
+
+//go:embed fixtures
+var fixtures embed.FS
+
+// options for the mock upstreams server
+type Options struct {
+	// address that the mock upstreams server listens on
+	Addr string
+	// base URL of the lcp instance that strava webhook events are sent to
+	LCPURL string
+	// subscription ID that strava webhook events are sent with
+	StravaSubscriptionID int64
+}
+
+// create a handler that serves fixture data for every upstream API that lcp talks to. Each upstream
+// is served under its own path prefix so a single server can be used for all of them.
+func Handler(opts Options) http.Handler {
+	mux := http.NewServeMux()
+	mountStrava(mux, opts)
+	mountAppleMusic(mux)
+	mountSteam(mux)
+	mountMapbox(mux)
+	mountGitHub(mux)
+	mountMinio(mux)
+	return withETags(mux)
+}
+
+// add an ETag to every successful GET response and reply with a 304 when the client already has
+// it, like most of the real upstreams do
+func withETags(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			next.ServeHTTP(w, r)
+			return
+		}
+		rec := httptest.NewRecorder()
+		next.ServeHTTP(rec, r)
+
+		for name, values := range rec.Header() {
+			w.Header()[name] = values
+		}
+		body := rec.Body.Bytes()
+		if rec.Code == http.StatusOK {
+			sum := sha256.Sum256(body)
+			etag := `"` + hex.EncodeToString(sum[:8]) + `"`
+			w.Header().Set("ETag", etag)
+			if r.Header.Get("If-None-Match") == etag {
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+		}
+		w.WriteHeader(rec.Code)
+		_, _ = w.Write(body)
+	})
+}
+
+// serve the mock upstreams until the server fails
+func Serve(opts Options) error {
+	base := "http://" + opts.Addr
+	if strings.HasPrefix(opts.Addr, ":") {
+		base = "http://localhost" + opts.Addr
+	}
+	lumber.Info("serving mock upstreams on", base, "with the following config:")
+	fmt.Printf(`
+[upstreams]
+github = "%[1]s/github/graphql"
+strava = "%[1]s/strava"
+mapbox = "%[1]s/mapbox"
+steam = "%[1]s/steam"
+steam_assets = "%[1]s/steam-assets"
+applemusic = "%[1]s/applemusic"
+
+[strava]
+maps_url = "%[1]s/mapbox-maps"
+minio_secure = false
+
+# also set MINIO_ENDPOINT=%[2]s
+
+`, base, strings.TrimPrefix(base, "http://"))
+	return http.ListenAndServe(opts.Addr, Handler(opts))
+}
+
+func loadFixture[T any](name string) T {
+	var data T
+	b, err := fixtures.ReadFile("fixtures/" + name)
+	if err != nil {
+		lumber.Fatal(err, "failed to read fixture", name)
+	}
+	err = json.Unmarshal(b, &data)
+	if err != nil {
+		lumber.Fatal(err, "failed to parse fixture", name)
+	}
+	return data
+}
+
+func writeJSON(w http.ResponseWriter, status int, data any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	err := json.NewEncoder(w).Encode(data)
+	if err != nil {
+		lumber.Error(err, "failed to write json")
+	}
+}
+
+// check that a request has a bearer token, writing a 401 if it doesn't
+func requireBearer(w http.ResponseWriter, r *http.Request) bool {
+	if !strings.HasPrefix(r.Header.Get("Authorization"), "Bearer ") ||
+		len(r.Header.Get("Authorization")) == len("Bearer ") {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"message": "Authorization Error"})
+		return false
+	}
+	return true
+}
@@ -0,0 +1,128 @@
+package mock
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+)
+
+type fixtureGame struct {
+	Name            string `json:"name"`
+	AppID           int    `json:"appid"`
+	ImgIconURL      string `json:"img_icon_url"`
+	RTimeLastPlayed int64  `json:"rtime_last_played"`
+	PlaytimeForever int    `json:"playtime_forever"`
+	Achievements    int    `json:"achievements"`
+}
+
+func mountSteam(mux *http.ServeMux) {
+	games := loadFixture[[]fixtureGame]("steam_games.json")
+
+	find := func(w http.ResponseWriter, r *http.Request) (fixtureGame, bool) {
+		if r.URL.Query().Get("key") == "" {
+			w.WriteHeader(http.StatusForbidden)
+			return fixtureGame{}, false
+		}
+		appID, err := strconv.Atoi(r.URL.Query().Get("appid"))
+		if err == nil {
+			for _, g := range games {
+				if g.AppID == appID {
+					return g, true
+				}
+			}
+		}
+		writeJSON(w, http.StatusBadRequest, map[string]any{
+			"playerstats": map[string]any{"error": "Requested app has no stats", "success": false},
+		})
+		return fixtureGame{}, false
+	}
+
+	mux.HandleFunc(
+		"GET /steam/IPlayerService/GetOwnedGames/v1",
+		func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Query().Get("key") == "" {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			writeJSON(w, http.StatusOK, map[string]any{
+				"response": map[string]any{"game_count": len(games), "games": games},
+			})
+		},
+	)
+	mux.HandleFunc(
+		"GET /steam/ISteamUserStats/GetPlayerAchievements/v0001",
+		func(w http.ResponseWriter, r *http.Request) {
+			g, ok := find(w, r)
+			if !ok {
+				return
+			}
+			if g.Achievements == 0 {
+				writeJSON(w, http.StatusBadRequest, map[string]any{
+					"playerstats": map[string]any{"error": "Requested app has no stats", "success": false},
+				})
+				return
+			}
+			achievements := []map[string]any{}
+			for i := range g.Achievements {
+				achieved := i%3 != 0
+				var unlockTime int64
+				if achieved {
+					unlockTime = g.RTimeLastPlayed - int64(i)*7200
+				}
+				achievements = append(achievements, map[string]any{
+					"apiname":    fmt.Sprintf("ACH_%02d", i),
+					"achieved":   map[bool]int{true: 1, false: 0}[achieved],
+					"unlocktime": unlockTime,
+				})
+			}
+			writeJSON(w, http.StatusOK, map[string]any{"playerstats": map[string]any{
+				"steamID":      "76561198000000000",
+				"gameName":     g.Name,
+				"achievements": achievements,
+				"success":      true,
+			}})
+		},
+	)
+	mux.HandleFunc(
+		"GET /steam/ISteamUserStats/GetSchemaForGame/v2",
+		func(w http.ResponseWriter, r *http.Request) {
+			g, ok := find(w, r)
+			if !ok {
+				return
+			}
+			achievements := []map[string]any{}
+			for i := range g.Achievements {
+				achievements = append(achievements, map[string]any{
+					"name":         fmt.Sprintf("ACH_%02d", i),
+					"defaultvalue": 0,
+					"displayName":  fmt.Sprintf("%s Achievement %d", g.Name, i+1),
+					"hidden":       0,
+					"description":  fmt.Sprintf("Complete challenge %d in %s", i+1, g.Name),
+					"icon": fmt.Sprintf(
+						"https://steamcdn-a.akamaihd.net/steamcommunity/public/images/apps/%d/ach_%02d.jpg",
+						g.AppID,
+						i,
+					),
+				})
+			}
+			writeJSON(w, http.StatusOK, map[string]any{"game": map[string]any{
+				"gameName":           g.Name,
+				"gameVersion":        "1",
+				"availableGameStats": map[string]any{"achievements": achievements},
+			}})
+		},
+	)
+	mux.HandleFunc(
+		"GET /steam-assets/store_item_assets/steam/apps/{id}/{file}",
+		func(w http.ResponseWriter, r *http.Request) {
+			// some older games don't have library images
+			appID, err := strconv.Atoi(r.PathValue("id"))
+			if err != nil || appID < 1000 {
+				http.NotFound(w, r)
+				return
+			}
+			w.Header().Set("Content-Type", "image/jpeg")
+			w.WriteHeader(http.StatusOK)
+		},
+	)
+}
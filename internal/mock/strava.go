@@ -0,0 +1,413 @@
+package mock
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gleich/lumber/v3"
+)
+
+type stravaActivity struct {
+	ID               uint64  `json:"id"`
+	Name             string  `json:"name"`
+	SportType        string  `json:"sport_type"`
+	Private          bool    `json:"private"`
+	Distance         float64 `json:"distance"`
+	MovingTime       int     `json:"moving_time"`
+	ElevationGain    float64 `json:"total_elevation_gain"`
+	AverageHeartrate float64 `json:"average_heartrate"`
+	AverageCadence   float64 `json:"average_cadence"`
+	AverageWatts     float64 `json:"average_watts"`
+	AverageTemp      float64 `json:"average_temp"`
+	HasHeartrate     bool    `json:"has_heartrate"`
+	Calories         float64 `json:"calories"`
+}
+
+const stravaAthleteID = 1234567
+
+func mountStrava(mux *http.ServeMux, opts Options) {
+	var (
+		activities = loadFixture[[]map[string]any]("strava_activities.json")
+		summaries  = loadFixture[[]stravaActivity]("strava_activities.json")
+		requests   atomic.Int64
+	)
+
+	find := func(w http.ResponseWriter, r *http.Request) (int, bool) {
+		id, err := strconv.ParseUint(r.PathValue("id"), 10, 64)
+		if err == nil {
+			for i, a := range summaries {
+				if a.ID == id {
+					return i, true
+				}
+			}
+		}
+		writeJSON(w, http.StatusNotFound, map[string]string{"message": "Record Not Found"})
+		return 0, false
+	}
+	api := func(handler http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			n := requests.Add(1)
+			w.Header().Set("X-RateLimit-Limit", "600,30000")
+			w.Header().Set("X-RateLimit-Usage", fmt.Sprintf("%d,%d", n%600, n))
+			w.Header().Set("X-ReadRateLimit-Limit", "300,15000")
+			w.Header().Set("X-ReadRateLimit-Usage", fmt.Sprintf("%d,%d", n%300, n))
+			if !requireBearer(w, r) {
+				return
+			}
+			handler(w, r)
+		}
+	}
+
+	// approves every authorization right away like an athlete clicking authorize would
+	mux.HandleFunc("GET /strava/oauth/authorize", func(w http.ResponseWriter, r *http.Request) {
+		redirect, err := url.Parse(r.URL.Query().Get("redirect_uri"))
+		if err != nil || redirect.Scheme == "" {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"message": "Bad Request"})
+			return
+		}
+		params := redirect.Query()
+		params.Set("state", r.URL.Query().Get("state"))
+		params.Set("code", "mock-code")
+		params.Set("scope", "read,"+r.URL.Query().Get("scope"))
+		redirect.RawQuery = params.Encode()
+		http.Redirect(w, r, redirect.String(), http.StatusFound)
+	})
+	mux.HandleFunc("POST /strava/oauth/token", func(w http.ResponseWriter, r *http.Request) {
+		if r.FormValue("grant_type") == "authorization_code" && r.FormValue("code") != "mock-code" {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"message": "Bad Request"})
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]any{
+			"token_type":    "Bearer",
+			"access_token":  fmt.Sprintf("mock-access-%d", time.Now().UnixNano()),
+			"refresh_token": "mock-refresh",
+			"expires_at":    time.Now().Add(6 * time.Hour).Unix(),
+			"expires_in":    int((6 * time.Hour).Seconds()),
+		})
+	})
+	mux.HandleFunc(
+		"GET /strava/api/v3/athlete/activities",
+		api(func(w http.ResponseWriter, r *http.Request) {
+			page, perPage := 1, 30
+			if p, err := strconv.Atoi(r.URL.Query().Get("page")); err == nil && p > 0 {
+				page = p
+			}
+			if p, err := strconv.Atoi(r.URL.Query().Get("per_page")); err == nil && p > 0 {
+				perPage = p
+			}
+			start := min((page-1)*perPage, len(activities))
+			end := min(start+perPage, len(activities))
+			writeJSON(w, http.StatusOK, activities[start:end])
+		}),
+	)
+	mux.HandleFunc(
+		"GET /strava/api/v3/activities/{id}",
+		api(func(w http.ResponseWriter, r *http.Request) {
+			i, ok := find(w, r)
+			if ok {
+				writeJSON(w, http.StatusOK, activities[i])
+			}
+		}),
+	)
+	mux.HandleFunc(
+		"GET /strava/api/v3/activities/{id}/streams",
+		api(func(w http.ResponseWriter, r *http.Request) {
+			i, ok := find(w, r)
+			if !ok {
+				return
+			}
+			points := 100
+			switch r.URL.Query().Get("resolution") {
+			case "medium":
+				points = 1000
+			case "high":
+				points = 10000
+			}
+			// fixture activities were recorded every 5 seconds like a device using smart recording
+			originalSize := summaries[i].MovingTime / 5
+			points = min(points, originalSize)
+			streams := map[string]any{}
+			for _, key := range strings.Split(r.URL.Query().Get("keys"), ",") {
+				if data := stream(summaries[i], key, points); data != nil {
+					streams[key] = map[string]any{
+						"data":          data,
+						"series_type":   "distance",
+						"original_size": originalSize,
+						"resolution":    r.URL.Query().Get("resolution"),
+					}
+				}
+			}
+			writeJSON(w, http.StatusOK, streams)
+		}),
+	)
+
+	mux.HandleFunc(
+		"GET /strava/api/v3/activities/{id}/zones",
+		api(func(w http.ResponseWriter, r *http.Request) {
+			i, ok := find(w, r)
+			if !ok {
+				return
+			}
+			a := summaries[i]
+			if !a.HasHeartrate {
+				writeJSON(w, http.StatusOK, []any{})
+				return
+			}
+			// most of the time is in the zone with the average heart rate and the rest is split
+			// between the zones next to it
+			bounds := []float64{0, 115, 152, 171, 190, -1}
+			buckets := make([]map[string]any, len(bounds)-1)
+			center := len(buckets) - 1
+			for j := range buckets {
+				buckets[j] = map[string]any{"min": bounds[j], "max": bounds[j+1], "time": 0}
+				if bounds[j+1] != -1 && a.AverageHeartrate < bounds[j+1] && center > j {
+					center = j
+				}
+			}
+			for j, share := range map[int]float64{center - 1: 0.2, center: 0.6, center + 1: 0.2} {
+				j = max(min(j, len(buckets)-1), 0)
+				buckets[j]["time"] = buckets[j]["time"].(int) +
+					int(share*float64(a.MovingTime))
+			}
+			writeJSON(w, http.StatusOK, []map[string]any{{
+				"score":                int(a.AverageHeartrate) / 2,
+				"distribution_buckets": buckets,
+				"type":                 "heartrate",
+				"sensor_based":         true,
+			}})
+		}),
+	)
+
+	mountStravaSubscriptions(mux)
+
+	// send a webhook event to lcp as if it came from strava. The request body can override any
+	// field of the event.
+	mux.HandleFunc("POST /_mock/strava/webhook", func(w http.ResponseWriter, r *http.Request) {
+		event := map[string]any{
+			"aspect_type":     "create",
+			"event_time":      time.Now().Unix(),
+			"object_id":       summaries[0].ID,
+			"object_type":     "activity",
+			"owner_id":        stravaAthleteID,
+			"subscription_id": opts.StravaSubscriptionID,
+			"updates":         map[string]string{},
+		}
+		if r.ContentLength != 0 {
+			err := json.NewDecoder(r.Body).Decode(&event)
+			if err != nil && err != io.EOF {
+				writeJSON(w, http.StatusBadRequest, map[string]string{"message": err.Error()})
+				return
+			}
+		}
+		body, err := json.Marshal(event)
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"message": err.Error()})
+			return
+		}
+
+		client := http.Client{
+			// report the redirect lcp sends for unknown routes instead of following it
+			CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+		}
+		resp, err := client.Post(
+			strings.TrimRight(opts.LCPURL, "/")+"/strava/event",
+			"application/json",
+			bytes.NewReader(body),
+		)
+		if err != nil {
+			lumber.Error(err, "failed to send webhook event to lcp")
+			writeJSON(w, http.StatusBadGateway, map[string]string{"message": err.Error()})
+			return
+		}
+		resp.Body.Close()
+		writeJSON(w, http.StatusOK, map[string]any{"event": event, "lcp_status": resp.StatusCode})
+	})
+}
+
+// generate a deterministic stream for an activity
+func stream(a stravaActivity, key string, points int) any {
+	wave := func(i int, period, amplitude float64) float64 {
+		return amplitude * math.Sin(float64(i)*2*math.Pi/period+float64(a.ID%17))
+	}
+	step := float64(a.MovingTime) / float64(max(points-1, 1))
+	ints := func(f func(i int) float64) []int {
+		data := make([]int, points)
+		for i := range data {
+			data[i] = int(math.Round(f(i)))
+		}
+		return data
+	}
+	floats := func(f func(i int) float64) []float64 {
+		data := make([]float64, points)
+		for i := range data {
+			data[i] = math.Round(f(i)*10) / 10
+		}
+		return data
+	}
+	speed := a.Distance / float64(max(a.MovingTime, 1))
+
+	switch key {
+	case "time":
+		return ints(func(i int) float64 { return float64(i) * step })
+	case "distance":
+		return floats(func(i int) float64 { return float64(i) * step * speed })
+	case "heartrate":
+		if !a.HasHeartrate {
+			return nil
+		}
+		return ints(func(i int) float64 {
+			warmup := math.Min(float64(i)/float64(points)*5, 1)
+			return a.AverageHeartrate*(0.8+0.2*warmup) + wave(i, 23, 8)
+		})
+	case "altitude":
+		return floats(func(i int) float64 {
+			return 120 + a.ElevationGain/4 + wave(i, 41, a.ElevationGain/4)
+		})
+	case "velocity_smooth":
+		return floats(func(i int) float64 { return math.Max(speed+wave(i, 13, speed/6), 0) })
+	case "cadence":
+		return ints(func(i int) float64 { return a.AverageCadence + wave(i, 7, 5) })
+	case "watts":
+		if a.AverageWatts == 0 {
+			return nil
+		}
+		return ints(func(i int) float64 { return math.Max(a.AverageWatts+wave(i, 11, 60), 0) })
+	case "temp":
+		return ints(func(i int) float64 { return a.AverageTemp + wave(i, float64(points), 2) })
+	}
+	return nil
+}
+
+type stravaSubscription struct {
+	ID            int64     `json:"id"`
+	ResourceState int       `json:"resource_state"`
+	ApplicationID int64     `json:"application_id"`
+	CallbackURL   string    `json:"callback_url"`
+	CreatedAt     time.Time `json:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
+}
+
+// serve the push subscriptions API. Like strava, only one subscription is allowed and the callback
+// has to answer a challenge before it is created.
+func mountStravaSubscriptions(mux *http.ServeMux) {
+	var (
+		mutex        sync.Mutex
+		subscription *stravaSubscription
+		nextID       int64 = 1
+	)
+	requireClient := func(w http.ResponseWriter, r *http.Request) bool {
+		if r.FormValue("client_id") == "" || r.FormValue("client_secret") == "" {
+			writeJSON(w, http.StatusUnauthorized, map[string]string{"message": "Authorization Error"})
+			return false
+		}
+		return true
+	}
+
+	mux.HandleFunc(
+		"POST /strava/api/v3/push_subscriptions",
+		func(w http.ResponseWriter, r *http.Request) {
+			if !requireClient(w, r) {
+				return
+			}
+			mutex.Lock()
+			exists := subscription != nil
+			mutex.Unlock()
+			if exists {
+				writeJSON(w, http.StatusBadRequest, map[string]any{
+					"message": "Bad Request",
+					"errors": []map[string]string{
+						{"resource": "PushSubscription", "code": "already exists"},
+					},
+				})
+				return
+			}
+
+			callbackURL, err := url.Parse(r.FormValue("callback_url"))
+			if err != nil || callbackURL.Host == "" {
+				writeJSON(w, http.StatusBadRequest, map[string]string{"message": "invalid callback_url"})
+				return
+			}
+			challenge := strconv.FormatInt(time.Now().UnixNano(), 36)
+			params := callbackURL.Query()
+			params.Set("hub.mode", "subscribe")
+			params.Set("hub.verify_token", r.FormValue("verify_token"))
+			params.Set("hub.challenge", challenge)
+			callbackURL.RawQuery = params.Encode()
+			client := http.Client{Timeout: 2 * time.Second}
+			resp, err := client.Get(callbackURL.String())
+			var answer map[string]string
+			if err == nil {
+				err = json.NewDecoder(resp.Body).Decode(&answer)
+				resp.Body.Close()
+			}
+			if err != nil || answer["hub.challenge"] != challenge {
+				writeJSON(w, http.StatusBadRequest, map[string]any{
+					"message": "Bad Request",
+					"errors": []map[string]string{
+						{
+							"resource": "PushSubscription",
+							"field":    "callback url",
+							"code":     "GET to callback URL does not return 200",
+						},
+					},
+				})
+				return
+			}
+
+			mutex.Lock()
+			subscription = &stravaSubscription{
+				ID:            nextID,
+				ResourceState: 2,
+				ApplicationID: 1,
+				CallbackURL:   r.FormValue("callback_url"),
+				CreatedAt:     time.Now().UTC().Truncate(time.Second),
+				UpdatedAt:     time.Now().UTC().Truncate(time.Second),
+			}
+			nextID++
+			id := subscription.ID
+			mutex.Unlock()
+			writeJSON(w, http.StatusCreated, map[string]int64{"id": id})
+		},
+	)
+	mux.HandleFunc(
+		"GET /strava/api/v3/push_subscriptions",
+		func(w http.ResponseWriter, r *http.Request) {
+			if !requireClient(w, r) {
+				return
+			}
+			mutex.Lock()
+			subscriptions := []stravaSubscription{}
+			if subscription != nil {
+				subscriptions = append(subscriptions, *subscription)
+			}
+			mutex.Unlock()
+			writeJSON(w, http.StatusOK, subscriptions)
+		},
+	)
+	mux.HandleFunc(
+		"DELETE /strava/api/v3/push_subscriptions/{id}",
+		func(w http.ResponseWriter, r *http.Request) {
+			if !requireClient(w, r) {
+				return
+			}
+			mutex.Lock()
+			defer mutex.Unlock()
+			if subscription == nil || r.PathValue("id") != strconv.FormatInt(subscription.ID, 10) {
+				writeJSON(w, http.StatusNotFound, map[string]string{"message": "Resource Not Found"})
+				return
+			}
+			subscription = nil
+			w.WriteHeader(http.StatusNoContent)
+		},
+	)
+}
@@ -1,24 +1,43 @@
 package secrets
 
 import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync/atomic"
+
 	"github.com/caarlos0/env/v11"
 	"github.com/gleich/lumber/v3"
 	"github.com/joho/godotenv"
+	"pkg.mattglei.ch/lcp-2/internal/config"
 )
 
-var SECRETS Secrets
+var current atomic.Pointer[Secrets]
+
+// get the currently loaded secrets. Values can change after a reload so they shouldn't be held onto
+// between fetches.
+func Get() Secrets {
+	s := current.Load()
+	if s == nil {
+		return Secrets{}
+	}
+	return *s
+}
 
 type Secrets struct {
-	ValidToken  string `env:"VALID_TOKEN"`
-	CacheFolder string `env:"CACHE_FOLDER"`
+	ValidToken  string            `env:"VALID_TOKEN"`
+	NamedTokens map[string]string `env:"NAMED_TOKENS"` // name:token pairs separated by commas
+	AdminToken  string            `env:"ADMIN_TOKEN"`  // admin endpoints are disabled if unset
+	CacheFolder string            `env:"CACHE_FOLDER"`
 
 	StravaClientID       string `env:"STRAVA_CLIENT_ID"`
 	StravaClientSecret   string `env:"STRAVA_CLIENT_SECRET"`
-	StravaOAuthCode      string `env:"STRAVA_OAUTH_CODE"`
 	StravaAccessToken    string `env:"STRAVA_ACCESS_TOKEN"`
-	StravaRefreshToken   string `env:"STRAVA_REFRESH_TOKEN"`
+	StravaRefreshToken   string `env:"STRAVA_REFRESH_TOKEN"` // optional with /strava/oauth/start
 	StravaSubscriptionID int64  `env:"STRAVA_SUBSCRIPTION_ID"`
 	StravaVerifyToken    string `env:"STRAVA_VERIFY_TOKEN"`
+	StravaTokensKey      string `env:"STRAVA_TOKENS_KEY"` // encrypts the persisted strava tokens
 	MapboxAccessToken    string `env:"MAPBOX_ACCESS_TOKEN"`
 	MinioEndpoint        string `env:"MINIO_ENDPOINT"`
 	MinioAccessKeyID     string `env:"MINIO_ACCESS_KEY_ID"`
@@ -33,15 +52,84 @@ type Secrets struct {
 	AppleMusicUserToken string `env:"APPLE_MUSIC_USER_TOKEN"`
 }
 
-func Load() {
-	err := godotenv.Load()
+// replace the currently loaded secrets. Used by tests and tools that load secrets themselves.
+func Set(s Secrets) {
+	current.Store(&s)
+}
+
+// read the secrets from the environment and the .env file if there is one. Variables already set in
+// the environment take precedence over the .env file. Every secret can also be read from a file by
+// setting the variable with a _FILE suffix to the path of the file (e.g. GITHUB_ACCESS_TOKEN_FILE).
+func Read() (Secrets, error) {
+	environment := env.ToMap(os.Environ())
+	dotenv, err := godotenv.Read()
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return Secrets{}, fmt.Errorf("%w loading .env file failed", err)
+	}
+	for key, value := range dotenv {
+		if _, set := environment[key]; !set {
+			environment[key] = value
+		}
+	}
+
+	params, err := env.GetFieldParams(&Secrets{})
 	if err != nil {
-		lumber.Fatal(err, "loading .env file failed")
+		return Secrets{}, err
 	}
-	loadedSecrets, err := env.ParseAs[Secrets]()
+	var errs []error
+	for _, param := range params {
+		path, set := environment[param.Key+"_FILE"]
+		if !set {
+			continue
+		}
+		if _, set := environment[param.Key]; set {
+			errs = append(errs, fmt.Errorf("both %s and %s_FILE are set", param.Key, param.Key))
+			continue
+		}
+		b, err := os.ReadFile(path)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%w reading %s_FILE failed", err, param.Key))
+			continue
+		}
+		environment[param.Key] = strings.TrimRight(string(b), "\r\n")
+	}
+
+	loadedSecrets, err := env.ParseAsWithOptions[Secrets](env.Options{Environment: environment})
+	if err != nil {
+		errs = append(errs, err)
+	}
+	return loadedSecrets, errors.Join(errs...)
+}
+
+// read the secrets and validate them against the given config, returning every problem found
+func Check(conf config.Config) (Secrets, []error) {
+	loadedSecrets, err := Read()
+	var errs []error
 	if err != nil {
-		lumber.Fatal(err, "parsing required env vars failed")
+		unwrapped := []error{err}
+		if joined, ok := err.(interface{ Unwrap() []error }); ok {
+			unwrapped = joined.Unwrap()
+		}
+		for _, err := range unwrapped {
+			var aggregateErr env.AggregateError
+			if errors.As(err, &aggregateErr) {
+				errs = append(errs, aggregateErr.Errors...)
+			} else {
+				errs = append(errs, err)
+			}
+		}
+	}
+	return loadedSecrets, append(errs, Validate(loadedSecrets, conf)...)
+}
+
+func Load() {
+	loadedSecrets, errs := Check(config.Get())
+	if len(errs) != 0 {
+		for _, err := range errs {
+			lumber.ErrorMsg(err)
+		}
+		lumber.FatalMsg(len(errs), "problem(s) found with secrets")
 	}
-	SECRETS = loadedSecrets
+	current.Store(&loadedSecrets)
 	lumber.Done("loaded secrets")
 }
@@ -0,0 +1,104 @@
+package secrets
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"pkg.mattglei.ch/lcp-2/internal/config"
+)
+
+var (
+	numericRegex  = regexp.MustCompile(`^\d+$`)
+	steamKeyRegex = regexp.MustCompile(`^[0-9A-Fa-f]{32}$`)
+	steamIDRegex  = regexp.MustCompile(`^\d{17}$`)
+)
+
+type secretError struct {
+	provider string
+	env      string
+	msg      string
+}
+
+func (e secretError) Error() string {
+	return fmt.Sprintf("%s: %s %s", e.provider, e.env, e.msg)
+}
+
+type validator struct {
+	provider string
+	errs     []error
+}
+
+func (v *validator) fail(env, msg string) {
+	v.errs = append(v.errs, secretError{provider: v.provider, env: env, msg: msg})
+}
+
+func (v *validator) required(env, value string) bool {
+	if strings.TrimSpace(value) == "" {
+		v.fail(env, "is required but not set")
+		return false
+	}
+	return true
+}
+
+func (v *validator) matches(env, value string, regex *regexp.Regexp, description string) {
+	if v.required(env, value) && !regex.MatchString(value) {
+		v.fail(env, "must be "+description)
+	}
+}
+
+// check the secrets needed by every enabled provider and return every problem found. An empty
+// slice means that the secrets are valid.
+func Validate(s Secrets, conf config.Config) []error {
+	v := validator{provider: "lcp"}
+	if s.ValidToken == "" && len(s.NamedTokens) == 0 {
+		v.fail("VALID_TOKEN", "or NAMED_TOKENS must be set")
+	}
+	v.required("CACHE_FOLDER", s.CacheFolder)
+	errs := v.errs
+
+	if conf.GitHub.Enabled {
+		v = validator{provider: "github"}
+		v.required("GITHUB_ACCESS_TOKEN", s.GitHubAccessToken)
+		errs = append(errs, v.errs...)
+	}
+
+	if conf.Strava.Enabled {
+		v = validator{provider: "strava"}
+		v.matches("STRAVA_CLIENT_ID", s.StravaClientID, numericRegex, "numeric")
+		v.required("STRAVA_CLIENT_SECRET", s.StravaClientSecret)
+		v.required("STRAVA_VERIFY_TOKEN", s.StravaVerifyToken)
+		v.required("STRAVA_TOKENS_KEY", s.StravaTokensKey)
+		if s.StravaSubscriptionID <= 0 {
+			v.fail("STRAVA_SUBSCRIPTION_ID", "must be a positive number")
+		}
+		if conf.Strava.MapRenderer == "mapbox" {
+			v.required("MAPBOX_ACCESS_TOKEN", s.MapboxAccessToken)
+		}
+		if v.required("MINIO_ENDPOINT", s.MinioEndpoint) && strings.Contains(s.MinioEndpoint, "://") {
+			v.fail("MINIO_ENDPOINT", "must be a host without a scheme")
+		}
+		v.required("MINIO_ACCESS_KEY_ID", s.MinioAccessKeyID)
+		v.required("MINIO_SECRET_KEY", s.MinioSecretKey)
+		errs = append(errs, v.errs...)
+	}
+
+	if conf.Steam.Enabled {
+		v = validator{provider: "steam"}
+		v.matches("STEAM_KEY", s.SteamKey, steamKeyRegex, "a 32 character hex string")
+		v.matches("STEAM_ID", s.SteamID, steamIDRegex, "a numeric 17 digit steam ID")
+		errs = append(errs, v.errs...)
+	}
+
+	if conf.AppleMusic.Enabled {
+		v = validator{provider: "applemusic"}
+		if v.required("APPLE_MUSIC_APP_TOKEN", s.AppleMusicAppToken) &&
+			strings.Count(s.AppleMusicAppToken, ".") != 2 {
+			v.fail("APPLE_MUSIC_APP_TOKEN", "must be a JWT")
+		}
+		v.required("APPLE_MUSIC_USER_TOKEN", s.AppleMusicUserToken)
+		errs = append(errs, v.errs...)
+	}
+
+	return errs
+}